@@ -8,6 +8,7 @@ import (
 	"github.com/arleyar/go-record-signer/internal/db"
 	"github.com/arleyar/go-record-signer/pkg/config"
 	"github.com/arleyar/go-record-signer/pkg/messaging"
+	"github.com/arleyar/go-record-signer/pkg/models"
 )
 
 func main() {
@@ -29,24 +30,35 @@ func main() {
 
 	log.Printf("Connected to database and NATS, batch size: %d", cfg.BatchSize)
 
+	cursor := database.NewPendingCursor(cfg.BatchSize)
 	for {
-		hasRecords := dispatchBatch(database, natsClient, cfg.BatchSize)
+		hasRecords := dispatchBatch(cursor, database, natsClient, cfg.OutboxMaxAttempts)
 		if !hasRecords {
 			log.Println("No more pending records, exiting")
 			break
 		}
 	}
 
+	// Batches left in the outbox by a failed publish, here or in an
+	// earlier run, are published by the relay.
+	if err := drainOutbox(database, natsClient, cfg.OutboxBatchSize, cfg.OutboxMaxAttempts); err != nil {
+		log.Fatalf("Failed to publish outbox, the relay will retry: %v", err)
+	}
+
 	log.Println("Dispatcher completed successfully")
 }
 
-func dispatchBatch(database *db.DB, natsClient *messaging.NATSClient, batchSize int) bool {
+// dispatchBatch claims the next batch of pending records from cursor,
+// writing its message to the outbox in the same transaction, and then relays
+// the outbox. The claim marks the records QUEUED atomically, so any number
+// of dispatchers can run side by side without publishing a record twice.
+func dispatchBatch(cursor *db.PendingCursor, database *db.DB, natsClient *messaging.NATSClient, maxAttempts int) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	records, err := database.GetPendingRecords(ctx, batchSize)
+	records, err := cursor.Next(ctx, messaging.NewBatchOutboxMessage)
 	if err != nil {
-		log.Printf("Error getting pending records: %v", err)
+		log.Printf("Error claiming pending records: %v", err)
 		return false
 	}
 
@@ -54,16 +66,42 @@ func dispatchBatch(database *db.DB, natsClient *messaging.NATSClient, batchSize
 		return false
 	}
 
-	if err = natsClient.PublishBatch(records); err != nil {
-		log.Printf("Error publishing batch: %v", err)
-		return true
-	}
+	log.Printf("Queued batch of %d records", len(records))
 
-	if err = database.UpdateRecordsToQueued(ctx, records); err != nil {
-		log.Printf("Error updating records to queued: %v", err)
-		return true
+	_, dead, err := database.RelayOutbox(ctx, 1, maxAttempts, natsClient.PublishOutbox)
+	logDeadMessages(dead)
+	if err != nil {
+		log.Printf("Error publishing batch, leaving it to the relay: %v", err)
 	}
 
-	log.Printf("Published batch of %d records", len(records))
 	return true
 }
+
+func drainOutbox(database *db.DB, natsClient *messaging.NATSClient, batchSize, maxAttempts int) error {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		published, dead, err := database.RelayOutbox(ctx, batchSize, maxAttempts, natsClient.PublishOutbox)
+		cancel()
+
+		logDeadMessages(dead)
+		if err != nil {
+			return err
+		}
+		if published == 0 && len(dead) == 0 {
+			return nil
+		}
+
+		if published > 0 {
+			log.Printf("Published %d outbox messages", published)
+		}
+	}
+}
+
+// logDeadMessages reports outbox messages the dispatcher gave up on. Their
+// records stay QUEUED until the reaper requeues them.
+func logDeadMessages(dead []*models.OutboxMessage) {
+	for _, msg := range dead {
+		log.Printf("Dead-lettered outbox message %s after %d attempts: %s",
+			msg.MessageID, msg.Attempts, msg.LastError)
+	}
+}
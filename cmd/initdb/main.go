@@ -1,9 +1,6 @@
 package main
 
 import (
-	"crypto/ed25519"
-	"crypto/rand"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,6 +9,7 @@ import (
 	"time"
 
 	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/keygen"
 	"github.com/arleyar/go-record-signer/pkg/config"
 	"github.com/arleyar/go-record-signer/pkg/crypto"
 	"github.com/arleyar/go-record-signer/pkg/models"
@@ -24,6 +22,10 @@ func main() {
 
 	cfg := config.LoadConfig()
 
+	if cfg.SignaturesRequired < 1 || cfg.SignaturesRequired > cfg.KeyCount {
+		log.Fatalf("SIGNATURES_REQUIRED must be between 1 and KEY_COUNT (%d), got %d", cfg.KeyCount, cfg.SignaturesRequired)
+	}
+
 	database, err := db.New(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -35,41 +37,47 @@ func main() {
 		log.Fatalf("Failed to create tables: %v", err)
 	}
 
-	encryptionKey, err := cfg.GetEncryptionKey()
+	encryptionKeys, err := cfg.GetEncryptionKeys()
 	if err != nil {
-		log.Fatalf("Failed to decode encryption key: %v", err)
+		log.Fatalf("Failed to decode encryption keys: %v", err)
 	}
 
-	if encryptionKey == nil {
-		encryptionKey, err = crypto.GenerateEncryptionKey()
+	if encryptionKeys[cfg.EncryptionKeyVersion] == nil {
+		encryptionKey, err := crypto.GenerateEncryptionKey()
 		if err != nil {
 			log.Fatalf("Failed to generate encryption key: %v", err)
 		}
+		encryptionKeys[cfg.EncryptionKeyVersion] = encryptionKey
 
 		encodedKey := base64.StdEncoding.EncodeToString(encryptionKey)
 		log.Printf("Generated new encryption key: %s", encodedKey)
 		log.Printf("IMPORTANT: Save this key in your ENCRYPTION_KEY environment variable for future use")
 	}
 
-	encryptor, err := crypto.NewKeyEncryptor(encryptionKey)
+	encryptor, err := crypto.NewVersionedKeyEncryptor(encryptionKeys, cfg.EncryptionKeyVersion)
 	if err != nil {
 		log.Fatalf("Failed to create key encryptor: %v", err)
 	}
 
-	log.Printf("Generating %d Ed25519 keys...", cfg.KeyCount)
-	keys, err := generateKeys(cfg.KeyCount, encryptor)
+	algorithms, err := keygen.ParseAlgorithms(cfg.KeyAlgorithms)
+	if err != nil {
+		log.Fatalf("Invalid key algorithms: %v", err)
+	}
+
+	log.Printf("Generating %d keys (%v)...", cfg.KeyCount, algorithms)
+	keys, err := keygen.Generate(cfg.KeyCount, algorithms, cfg.KeyMaxSignatures, cfg.KeyLabel)
 	if err != nil {
 		log.Fatalf("Failed to generate keys: %v", err)
 	}
 
 	log.Println("Storing encrypted keys in database...")
-	if err := database.InsertSigningKeys(keys); err != nil {
+	if err := database.InsertSigningKeys(keys.Keys, keys.Wrapper(encryptor), "initdb"); err != nil {
 		log.Fatalf("Failed to store keys in database: %v", err)
 	}
 	log.Printf("Generated and stored %d keys", cfg.KeyCount)
 
-	log.Printf("Generating %d records...", cfg.RecordCount)
-	records, err := generateRecords(cfg.RecordCount)
+	log.Printf("Generating %d records requiring %d signatures each...", cfg.RecordCount, cfg.SignaturesRequired)
+	records, err := generateRecords(cfg.RecordCount, cfg.SignaturesRequired)
 	if err != nil {
 		log.Fatalf("Failed to generate records: %v", err)
 	}
@@ -87,42 +95,7 @@ func main() {
 	os.Exit(0)
 }
 
-func generateKey(encryptor *crypto.KeyEncryptor) (*models.SigningKey, error) {
-	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
-	}
-
-	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(privKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal private key: %w", err)
-	}
-
-	encryptedKey, err := encryptor.Encrypt(pkcs8Key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
-	}
-
-	return &models.SigningKey{
-		PublicKey:  pubKey,
-		PrivateKey: encryptedKey,
-		InUse:      false,
-	}, nil
-}
-
-func generateKeys(count int, encryptor *crypto.KeyEncryptor) ([]*models.SigningKey, error) {
-	keys := make([]*models.SigningKey, 0, count)
-	for i := 0; i < count; i++ {
-		key, err := generateKey(encryptor)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate key %d: %w", i+1, err)
-		}
-		keys = append(keys, key)
-	}
-	return keys, nil
-}
-
-func generateRecord() (*models.Record, error) {
+func generateRecord(signaturesRequired int) (*models.Record, error) {
 	payload := map[string]interface{}{
 		"id":        uuid.NewString(),
 		"timestamp": time.Now().UnixNano(),
@@ -134,17 +107,18 @@ func generateRecord() (*models.Record, error) {
 	}
 
 	record := &models.Record{
-		Payload: json.RawMessage(jsonData),
+		Payload:            json.RawMessage(jsonData),
+		SignaturesRequired: signaturesRequired,
 	}
 
 	return record, nil
 }
 
-func generateRecords(count int) ([]*models.Record, error) {
+func generateRecords(count int, signaturesRequired int) ([]*models.Record, error) {
 	records := make([]*models.Record, 0, count)
 
 	for i := 0; i < count; i++ {
-		record, err := generateRecord()
+		record, err := generateRecord(signaturesRequired)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate record %d: %w", i, err)
 		}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+const actor = "keyadmin"
+
+const usage = `Usage: keyadmin <command> [flags]
+
+Commands:
+  list                                      show every key with its state and validity window
+  retire   -key-id N [-reason R]            take an active key out of rotation
+  revoke   -key-id N [-reason R]            revoke a key; verify flags its signatures
+  validity -key-id N [-not-before T] [-not-after T] [-reason R]
+                                            set the window (RFC 3339) in which a key may sign
+  quota    -key-id N -max M [-reason R]     cap the key at M signatures (0 removes the cap)
+  label    -key-id N -label L [-reason R]   set the label weighted key selection uses
+  events   [-key-id N] [-limit N]           show the key audit log, newest first
+  fairness [-batches N] [-batch-size N]     simulate each key selection strategy on the
+                                            current pool and show how evenly it spreads batches
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	keyID := flags.Int("key-id", 0, "ID of the signing key")
+	notBefore := flags.String("not-before", "", "start of the validity window (RFC 3339)")
+	notAfter := flags.String("not-after", "", "end of the validity window (RFC 3339)")
+	reason := flags.String("reason", "", "reason recorded in the key audit log")
+	limit := flags.Int("limit", 50, "maximum number of audit events to show")
+	maxSignatures := flags.Int64("max", -1, "maximum number of signatures for the key")
+	label := flags.String("label", "", "label of the signing key")
+	batches := flags.Int("batches", 1000, "number of batches to simulate")
+	batchSize := flags.Int("batch-size", 0, "records per simulated batch (defaults to BATCH_SIZE)")
+	flags.Parse(args)
+
+	cfg := config.LoadConfig()
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "list":
+		err = listKeys(ctx, database)
+	case "retire":
+		err = setState(ctx, database, *keyID, models.KeyStateRetired, *reason)
+	case "revoke":
+		err = setState(ctx, database, *keyID, models.KeyStateRevoked, *reason)
+	case "validity":
+		err = setValidity(ctx, database, *keyID, *notBefore, *notAfter, *reason)
+	case "quota":
+		err = setQuota(ctx, database, *keyID, *maxSignatures, *reason)
+	case "label":
+		err = setLabel(ctx, database, *keyID, *label, *reason)
+	case "events":
+		err = listEvents(ctx, database, *keyID, *limit)
+	case "fairness":
+		if *batchSize <= 0 {
+			*batchSize = cfg.BatchSize
+		}
+		err = fairness(ctx, database, cfg, *batches, *batchSize)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		database.Close()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("keyadmin %s failed: %v", command, err)
+	}
+}
+
+func listKeys(ctx context.Context, database *db.DB) error {
+	keys, err := database.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tALGORITHM\tLABEL\tSTATE\tUSABLE\tSIGNATURES\tNOT BEFORE\tNOT AFTER\tLAST USED\tLEASE")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\t%s\n",
+			key.ID, key.Algorithm, formatLabel(key.Label), key.State, key.Usable(now), formatUsage(key),
+			formatTime(key.NotBefore), formatTime(key.NotAfter), formatTime(key.LastUsed), formatLease(key, now))
+	}
+
+	return w.Flush()
+}
+
+func listEvents(ctx context.Context, database *db.DB, keyID int, limit int) error {
+	events, err := database.GetKeyAuditEvents(ctx, keyID, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tKEY\tEVENT\tACTOR\tREASON")
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+			formatTime(&event.CreatedAt), event.KeyID, event.Event, event.Actor, event.Reason)
+	}
+
+	return w.Flush()
+}
+
+func setState(ctx context.Context, database *db.DB, keyID int, state models.KeyState, reason string) error {
+	if keyID <= 0 {
+		return fmt.Errorf("-key-id is required")
+	}
+
+	key, err := database.SetKeyState(ctx, keyID, state, actor, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Key %d is now %s", key.ID, key.State)
+	if key.InUse {
+		if key.State == models.KeyStateRevoked {
+			log.Printf("Key %d is currently leased; the batch in progress will not store its signatures", key.ID)
+		} else {
+			log.Printf("Key %d is currently leased; the batch in progress will finish with it", key.ID)
+		}
+	}
+
+	return nil
+}
+
+func setQuota(ctx context.Context, database *db.DB, keyID int, maxSignatures int64, reason string) error {
+	if keyID <= 0 {
+		return fmt.Errorf("-key-id is required")
+	}
+	if maxSignatures < 0 {
+		return fmt.Errorf("-max is required")
+	}
+
+	if err := database.SetKeyQuota(ctx, keyID, maxSignatures, actor, reason); err != nil {
+		return err
+	}
+
+	log.Printf("Key %d may produce at most %d signatures (0 is unlimited)", keyID, maxSignatures)
+	return nil
+}
+
+func setLabel(ctx context.Context, database *db.DB, keyID int, label string, reason string) error {
+	if keyID <= 0 {
+		return fmt.Errorf("-key-id is required")
+	}
+
+	if err := database.SetKeyLabel(ctx, keyID, label, actor, reason); err != nil {
+		return err
+	}
+
+	log.Printf("Key %d is now labelled %s", keyID, formatLabel(label))
+	return nil
+}
+
+// fairness simulates every key selection strategy on a copy of the current
+// pool and reports how evenly each spreads batches over the usable keys.
+// Jain's index is 1 for a perfectly even spread and 1/n when one of n keys
+// gets every batch.
+func fairness(ctx context.Context, database *db.DB, cfg *config.Config, batches int, batchSize int) error {
+	if batches <= 0 {
+		return fmt.Errorf("-batches must be positive")
+	}
+
+	weights, err := db.ParseKeyWeights(cfg.KeySelectionWeights)
+	if err != nil {
+		return fmt.Errorf("invalid KEY_SELECTION_WEIGHTS: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STRATEGY\tKEYS\tBATCHES\tMIN\tMAX\tMEAN\tSTDDEV\tJAIN")
+	for _, name := range db.KeySelectors {
+		selector, err := db.NewKeySelector(name, weights)
+		if err != nil {
+			return err
+		}
+
+		counts, err := database.SimulateKeySelection(ctx, selector, batches, batchSize)
+		if err != nil {
+			return err
+		}
+
+		s := spread(counts)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.2f\t%.2f\t%.3f\n",
+			name, len(counts), s.total, s.min, s.max, s.mean, s.stddev, s.jain)
+	}
+
+	return w.Flush()
+}
+
+type batchSpread struct {
+	total, min, max    int
+	mean, stddev, jain float64
+}
+
+func spread(counts map[int]int) batchSpread {
+	var s batchSpread
+	if len(counts) == 0 {
+		return s
+	}
+
+	s.min = math.MaxInt
+	var sumSquares float64
+	for _, count := range counts {
+		s.total += count
+		s.min = min(s.min, count)
+		s.max = max(s.max, count)
+		sumSquares += float64(count) * float64(count)
+	}
+
+	n := float64(len(counts))
+	s.mean = float64(s.total) / n
+	s.stddev = math.Sqrt(max(sumSquares/n-s.mean*s.mean, 0))
+	if sumSquares > 0 {
+		s.jain = float64(s.total) * float64(s.total) / (n * sumSquares)
+	}
+
+	return s
+}
+
+func setValidity(ctx context.Context, database *db.DB, keyID int, notBefore, notAfter string, reason string) error {
+	if keyID <= 0 {
+		return fmt.Errorf("-key-id is required")
+	}
+
+	from, err := parseTime(notBefore)
+	if err != nil {
+		return fmt.Errorf("invalid -not-before: %w", err)
+	}
+
+	until, err := parseTime(notAfter)
+	if err != nil {
+		return fmt.Errorf("invalid -not-after: %w", err)
+	}
+
+	if from != nil && until != nil && !from.Before(*until) {
+		return fmt.Errorf("-not-before must be earlier than -not-after")
+	}
+
+	if err := database.SetKeyValidity(ctx, keyID, from, until, actor, reason); err != nil {
+		return err
+	}
+
+	log.Printf("Key %d may sign from %s until %s", keyID, formatTime(from), formatTime(until))
+	return nil
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("expected RFC 3339 time: %w", err)
+	}
+
+	return &t, nil
+}
+
+func formatUsage(key *models.SigningKey) string {
+	if key.MaxSignatures <= 0 {
+		return fmt.Sprintf("%d", key.SignatureCount)
+	}
+	return fmt.Sprintf("%d/%d", key.SignatureCount, key.MaxSignatures)
+}
+
+// formatLease shows who holds a key and until when. A key held without an
+// expiry, as the flag lock strategy does, is held until released.
+func formatLease(key *models.SigningKey, now time.Time) string {
+	if !key.InUse {
+		return "-"
+	}
+	if key.LeasedUntil == nil {
+		return fmt.Sprintf("%s (held until released)", key.LeasedBy)
+	}
+	if !now.Before(*key.LeasedUntil) {
+		return fmt.Sprintf("%s (expired)", key.LeasedBy)
+	}
+	return fmt.Sprintf("%s until %s", key.LeasedBy, formatTime(key.LeasedUntil))
+}
+
+func formatLabel(label string) string {
+	if label == "" {
+		return "-"
+	}
+	return label
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/keystore"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/keyservice"
+)
+
+func main() {
+	log.Println("Starting Key Agent")
+
+	cfg := config.LoadConfig()
+
+	keys, err := cfg.GetEncryptionKeys()
+	if err != nil {
+		log.Fatalf("Failed to get encryption keys: %v", err)
+	}
+
+	encryptor, err := crypto.NewVersionedKeyEncryptor(keys, cfg.EncryptionKeyVersion)
+	if err != nil {
+		log.Fatalf("Failed to create key encryptor: %v", err)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	store := keystore.NewDBStore(database, encryptor)
+	defer store.Close()
+
+	count, err := store.Unseal(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to unseal signing keys: %v", err)
+	}
+	log.Printf("Unsealed %d signing keys", count)
+
+	auditOut, err := openAuditLog(cfg.KeyAgentAuditLog)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditOut.Close()
+
+	listener, err := listenUnix(cfg.KeyAgentSocket)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.KeyAgentSocket, err)
+	}
+
+	server := &http.Server{
+		Handler: keyservice.NewServer(store, keyservice.ServerOptions{
+			Token: cfg.KeyServerToken,
+			Audit: log.New(auditOut, "audit: ", log.LstdFlags|log.LUTC),
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down key agent: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", cfg.KeyAgentSocket)
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Key agent failed: %v", err)
+	}
+
+	log.Printf("Key Agent stopped")
+}
+
+// listenUnix replaces any stale socket at path and restricts the new one to
+// the agent's user, in the same way ssh-agent does.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func openAuditLog(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopCloser{os.Stderr}, nil
+	}
+
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/keystore"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/keyservice"
+)
+
+func main() {
+	log.Println("Starting Key Server")
+
+	cfg := config.LoadConfig()
+
+	keys, err := cfg.GetEncryptionKeys()
+	if err != nil {
+		log.Fatalf("Failed to get encryption keys: %v", err)
+	}
+
+	encryptor, err := crypto.NewVersionedKeyEncryptor(keys, cfg.EncryptionKeyVersion)
+	if err != nil {
+		log.Fatalf("Failed to create key encryptor: %v", err)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	store := keystore.NewDBStore(database, encryptor)
+	defer store.Close()
+
+	if cfg.KeyServerToken == "" {
+		log.Printf("KEYSERVER_TOKEN is not set, requests will not be authenticated")
+	}
+
+	server := &http.Server{
+		Addr:              cfg.KeyServerAddr,
+		Handler:           keyservice.NewServer(store, keyservice.ServerOptions{Token: cfg.KeyServerToken}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down key server: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", cfg.KeyServerAddr)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Key server failed: %v", err)
+	}
+
+	log.Printf("Key Server stopped")
+}
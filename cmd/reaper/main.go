@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/metrics"
+	"github.com/arleyar/go-record-signer/pkg/config"
+)
+
+var (
+	runs            = expvar.NewInt("reaper_runs")
+	errorsTotal     = expvar.NewInt("reaper_errors")
+	requeuedTotal   = expvar.NewInt("reaper_requeued_records")
+	requeuedLastRun = expvar.NewInt("reaper_requeued_last_run")
+	lastRun         = expvar.NewInt("reaper_last_run_unix")
+)
+
+func main() {
+	once := flag.Bool("once", false, "run a single reaper pass and exit")
+	flag.Parse()
+
+	log.Println("Starting Queue Reaper")
+
+	cfg := config.LoadConfig()
+
+	if cfg.QueuedTTL <= 0 {
+		log.Fatalf("QUEUED_TTL must be positive, got %s", cfg.QueuedTTL)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	metrics.Serve(cfg.MetricsAddr)
+
+	log.Printf("Requeueing records QUEUED for longer than %v, checking every %v", cfg.QueuedTTL, cfg.ReaperInterval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(cfg.ReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := reap(ctx, database, cfg.QueuedTTL, cfg.BatchSize); err != nil {
+			errorsTotal.Add(1)
+			log.Printf("Reaper pass failed: %v", err)
+		}
+
+		if *once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Queue Reaper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap moves every record queued longer than ttl back to PENDING, in chunks
+// of batchSize, logging each one.
+func reap(ctx context.Context, database *db.DB, ttl time.Duration, batchSize int) error {
+	runs.Add(1)
+	lastRun.Set(time.Now().Unix())
+
+	cutoff := time.Now().Add(-ttl)
+	total := 0
+	defer func() { requeuedLastRun.Set(int64(total)) }()
+
+	for {
+		records, err := database.RequeueStaleRecords(ctx, cutoff, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if record.QueuedAt != nil {
+				log.Printf("Requeued record %d, queued at %s", record.ID, record.QueuedAt.UTC().Format(time.RFC3339))
+			} else {
+				log.Printf("Requeued record %d, queued before queue times were recorded", record.ID)
+			}
+		}
+
+		total += len(records)
+		requeuedTotal.Add(int64(len(records)))
+
+		if len(records) == 0 || len(records) < batchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Requeued %d stale records", total)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+const usage = `Usage: recordadmin <command> [flags]
+
+Commands:
+  failed [-limit N]             list records parked as FAILED with their last error
+  retry  -ids 1,2,3 | -all      move FAILED records back to PENDING for the next dispatch
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	limit := flags.Int("limit", 100, "maximum number of records to show")
+	ids := flags.String("ids", "", "comma-separated IDs of the records to retry")
+	all := flags.Bool("all", false, "retry every FAILED record")
+	flags.Parse(args)
+
+	cfg := config.LoadConfig()
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "failed":
+		err = listFailed(ctx, database, *limit)
+	case "retry":
+		err = retry(ctx, database, *ids, *all)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		database.Close()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("recordadmin %s failed: %v", command, err)
+	}
+}
+
+func listFailed(ctx context.Context, database *db.DB, limit int) error {
+	records, err := database.GetFailedRecords(ctx, 0, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tATTEMPTS\tQUEUED AT\tLAST ERROR")
+	for _, record := range records {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", record.ID, record.Attempts, formatTime(record.QueuedAt), record.LastError)
+	}
+
+	return w.Flush()
+}
+
+func retry(ctx context.Context, database *db.DB, ids string, all bool) error {
+	recordIDs, err := parseIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	if len(recordIDs) == 0 && !all {
+		return fmt.Errorf("-ids or -all is required")
+	}
+	if len(recordIDs) > 0 && all {
+		return fmt.Errorf("-ids and -all are mutually exclusive")
+	}
+
+	retried, err := database.RetryFailedRecords(ctx, recordIDs)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Moved %d %s records back to %s", retried, models.RecordStatusFailed, models.RecordStatusPending)
+	return nil
+}
+
+func parseIDs(value string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid record ID %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
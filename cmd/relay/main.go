@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/messaging"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func main() {
+	once := flag.Bool("once", false, "publish the outbox once and exit")
+	flag.Parse()
+
+	log.Println("Starting Outbox Relay")
+
+	cfg := config.LoadConfig()
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	natsClient, err := messaging.New(cfg.NatsURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	defer natsClient.Close()
+
+	log.Printf("Relaying outbox every %v, pruning messages published more than %v ago",
+		cfg.OutboxPollInterval, cfg.OutboxRetention)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(cfg.OutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		relay(ctx, database, natsClient, cfg)
+
+		if *once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Outbox Relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes the outbox until it is empty or a publish fails, then
+// prunes messages past the retention period.
+func relay(ctx context.Context, database *db.DB, natsClient *messaging.NATSClient, cfg *config.Config) {
+	for ctx.Err() == nil {
+		published, dead, err := database.RelayOutbox(ctx, cfg.OutboxBatchSize, cfg.OutboxMaxAttempts, natsClient.PublishOutbox)
+		if published > 0 {
+			log.Printf("Published %d outbox messages", published)
+		}
+		logDeadMessages(dead)
+		if err != nil {
+			log.Printf("Outbox relay failed: %v", err)
+			return
+		}
+		if published == 0 && len(dead) == 0 {
+			break
+		}
+	}
+
+	if cfg.OutboxRetention > 0 {
+		pruned, err := database.PruneOutbox(ctx, time.Now().Add(-cfg.OutboxRetention))
+		if err != nil {
+			log.Printf("Outbox prune failed: %v", err)
+		} else if pruned > 0 {
+			log.Printf("Pruned %d published outbox messages", pruned)
+		}
+	}
+}
+
+// logDeadMessages reports outbox messages the relay gave up on. Their
+// records stay QUEUED until the reaper requeues them.
+func logDeadMessages(dead []*models.OutboxMessage) {
+	for _, msg := range dead {
+		log.Printf("Dead-lettered outbox message %s after %d attempts: %s",
+			msg.MessageID, msg.Attempts, msg.LastError)
+	}
+}
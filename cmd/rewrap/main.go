@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func main() {
+	start := time.Now()
+	log.Println("Starting key rewrap")
+
+	cfg := config.LoadConfig()
+
+	keys, err := cfg.GetEncryptionKeys()
+	if err != nil {
+		log.Fatalf("Failed to get encryption keys: %v", err)
+	}
+
+	encryptor, err := crypto.NewVersionedKeyEncryptor(keys, cfg.EncryptionKeyVersion)
+	if err != nil {
+		log.Fatalf("Failed to create key encryptor: %v", err)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	log.Printf("Rewrapping signing keys under encryption key version %d...", encryptor.ActiveVersion())
+
+	count, err := database.RewrapSigningKeys(context.Background(), func(key *models.SigningKey) (bool, error) {
+		fromVersion, fromFormat := key.KEKVersion, key.WrapFormat
+		changed, err := encryptor.RewrapKey(key)
+		if changed {
+			log.Printf("Rewrapped key %d from version %d (format %d) to version %d (format %d)",
+				key.ID, fromVersion, fromFormat, key.KEKVersion, key.WrapFormat)
+		}
+		return changed, err
+	})
+	if err != nil {
+		log.Fatalf("Failed to rewrap keys: %v", err)
+	}
+
+	log.Printf("Rewrapped %d keys in %v", count, time.Since(start))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/keygen"
+	"github.com/arleyar/go-record-signer/internal/rotation"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+const actor = "rotator"
+
+func main() {
+	once := flag.Bool("once", false, "run a single rotation pass and exit")
+	flag.Parse()
+
+	log.Println("Starting Key Rotator")
+
+	cfg := config.LoadConfig()
+
+	keys, err := cfg.GetEncryptionKeys()
+	if err != nil {
+		log.Fatalf("Failed to get encryption keys: %v", err)
+	}
+
+	encryptor, err := crypto.NewVersionedKeyEncryptor(keys, cfg.EncryptionKeyVersion)
+	if err != nil {
+		log.Fatalf("Failed to create key encryptor: %v", err)
+	}
+
+	algorithms, err := keygen.ParseAlgorithms(cfg.KeyAlgorithms)
+	if err != nil {
+		log.Fatalf("Invalid key algorithms: %v", err)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
+	policy := rotation.Policy{
+		PoolSize:      cfg.KeyCount,
+		MaxAge:        cfg.RotationMaxAge,
+		MaxSignatures: cfg.RotationMaxSignatures,
+	}
+
+	log.Printf("Rotation policy: pool size %d, max age %v, max signatures %d, checking every %v",
+		policy.PoolSize, policy.MaxAge, policy.MaxSignatures, cfg.RotationInterval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		if err := rotate(ctx, database, encryptor, algorithms, cfg, policy); err != nil {
+			log.Fatalf("Rotation failed: %v", err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(cfg.RotationInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := rotate(ctx, database, encryptor, algorithms, cfg, policy); err != nil {
+			log.Printf("Rotation failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Key Rotator stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func rotate(ctx context.Context, database *db.DB, encryptor *crypto.KeyEncryptor, algorithms []crypto.Algorithm, cfg *config.Config, policy rotation.Policy) error {
+	result, err := database.RotateSigningKeys(ctx, actor, func(active []*models.SigningKey) (*db.KeyRotation, error) {
+		plan := policy.Plan(active, time.Now())
+
+		batch, err := keygen.Generate(plan.Create, algorithms, cfg.KeyMaxSignatures, cfg.KeyLabel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate keys: %w", err)
+		}
+
+		return &db.KeyRotation{
+			Retire: plan.Retire,
+			Create: batch.Keys,
+			Wrap:   batch.Wrapper(encryptor),
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for keyID, reason := range result.Retire {
+		log.Printf("Retired key %d: %s", keyID, reason)
+	}
+	for _, key := range result.Create {
+		log.Printf("Created key %d (%s)", key.ID, key.Algorithm)
+	}
+
+	return nil
+}
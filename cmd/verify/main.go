@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/canonical"
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/merkle"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+const pageSize = 1000
+
+type Summary struct {
+	Checked    int   `json:"checked"`
+	Valid      int   `json:"valid"`
+	Invalid    int   `json:"invalid"`
+	Missing    int   `json:"missing"`
+	UnknownKey int   `json:"unknown_key"`
+	Revoked    int   `json:"revoked"`
+	Parked     int   `json:"parked"`
+	InvalidIDs []int `json:"invalid_ids,omitempty"`
+	MissingIDs []int `json:"missing_ids,omitempty"`
+	UnknownIDs []int `json:"unknown_key_ids,omitempty"`
+	RevokedIDs []int `json:"revoked_ids,omitempty"`
+	ParkedIDs  []int `json:"parked_ids,omitempty"`
+
+	Attested           int   `json:"attested"`
+	AttestationInvalid int   `json:"attestation_invalid"`
+	AttestationIDs     []int `json:"attestation_invalid_ids,omitempty"`
+}
+
+func (s *Summary) Failed() bool {
+	return s.Invalid > 0 || s.Missing > 0 || s.UnknownKey > 0 || s.Revoked > 0 || s.Parked > 0 || s.AttestationInvalid > 0
+}
+
+// attestationCache loads each batch attestation once and remembers whether
+// its root signature verified.
+type attestationCache struct {
+	database *db.DB
+	keys     map[int]*models.SigningKey
+	entries  map[int]attestationEntry
+}
+
+type attestationEntry struct {
+	attestation *models.BatchAttestation
+	err         error
+}
+
+func main() {
+	fromID := flag.Int("from-id", 0, "first record ID to verify (inclusive)")
+	toID := flag.Int("to-id", 0, "last record ID to verify (inclusive)")
+	keyID := flag.Int("key-id", 0, "only verify records with a signature from this key")
+	since := flag.String("since", "", "only verify records signed at or after this RFC 3339 time")
+	until := flag.String("until", "", "only verify records signed before this RFC 3339 time")
+	jsonOutput := flag.Bool("json", false, "print the summary as JSON")
+	flag.Parse()
+
+	filter := db.RecordFilter{FromID: *fromID, ToID: *toID, KeyID: *keyID}
+
+	var err error
+	if filter.SignedAfter, err = parseTime(*since); err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+	if filter.SignedBefore, err = parseTime(*until); err != nil {
+		log.Fatalf("Invalid -until: %v", err)
+	}
+
+	cfg := config.LoadConfig()
+
+	database, err := db.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+
+	summary, err := verifyRecords(ctx, database, filter)
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+
+	if *jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+			log.Fatalf("Failed to encode summary: %v", err)
+		}
+	} else {
+		log.Printf("Checked %d records: %d valid, %d invalid, %d missing, %d unknown key, %d revoked key, %d parked",
+			summary.Checked, summary.Valid, summary.Invalid, summary.Missing, summary.UnknownKey, summary.Revoked, summary.Parked)
+		if summary.Attested > 0 || summary.AttestationInvalid > 0 {
+			log.Printf("Checked %d batch inclusion proofs: %d invalid",
+				summary.Attested+summary.AttestationInvalid, summary.AttestationInvalid)
+		}
+	}
+
+	if summary.Failed() {
+		database.Close()
+		os.Exit(1)
+	}
+}
+
+func verifyRecords(ctx context.Context, database *db.DB, filter db.RecordFilter) (*Summary, error) {
+	keys, err := database.GetSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keysByID := make(map[int]*models.SigningKey, len(keys))
+	for _, key := range keys {
+		keysByID[key.ID] = key
+	}
+
+	attestations := &attestationCache{
+		database: database,
+		keys:     keysByID,
+		entries:  make(map[int]attestationEntry),
+	}
+
+	summary := &Summary{}
+	afterID := 0
+	for {
+		records, err := database.GetRecordsPage(ctx, filter, afterID, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) == 0 {
+			return summary, nil
+		}
+
+		for _, record := range records {
+			verifyRecord(record, keysByID, summary)
+
+			if record.AttestationID != nil {
+				if err := attestations.verifyInclusion(ctx, record); err != nil {
+					log.Printf("Record %d: batch attestation: %v", record.ID, err)
+					summary.AttestationInvalid++
+					summary.AttestationIDs = append(summary.AttestationIDs, record.ID)
+				} else {
+					summary.Attested++
+				}
+			}
+		}
+
+		afterID = records[len(records)-1].ID
+	}
+}
+
+// verifyRecord counts a record as valid only if it carries its required
+// number of signatures and every one of them verifies with a key that has
+// not been revoked.
+func verifyRecord(record *models.Record, keys map[int]*models.SigningKey, summary *Summary) {
+	summary.Checked++
+
+	unknown, invalid, revoked := false, false, false
+	for _, signature := range record.Signatures {
+		key, ok := keys[signature.KeyID]
+		if !ok {
+			unknown = true
+			continue
+		}
+
+		if key.State == models.KeyStateRevoked {
+			revoked = true
+		}
+
+		payload, err := canonical.Apply(canonical.Scheme(record.Canonicalization), record.Payload)
+		if err == nil {
+			err = crypto.Verify(crypto.Algorithm(key.Algorithm), key.PublicKey, payload, signature.Signature)
+		}
+		if err != nil {
+			if !errors.Is(err, crypto.ErrInvalidSignature) {
+				log.Printf("Record %d: %v", record.ID, err)
+			}
+			invalid = true
+		}
+	}
+
+	switch {
+	case unknown:
+		summary.UnknownKey++
+		summary.UnknownIDs = append(summary.UnknownIDs, record.ID)
+	case invalid:
+		summary.Invalid++
+		summary.InvalidIDs = append(summary.InvalidIDs, record.ID)
+	case revoked:
+		summary.Revoked++
+		summary.RevokedIDs = append(summary.RevokedIDs, record.ID)
+	case len(record.Signatures) < max(record.SignaturesRequired, 1) && record.Status == models.RecordStatusFailed:
+		summary.Parked++
+		summary.ParkedIDs = append(summary.ParkedIDs, record.ID)
+	case len(record.Signatures) < max(record.SignaturesRequired, 1):
+		summary.Missing++
+		summary.MissingIDs = append(summary.MissingIDs, record.ID)
+	default:
+		summary.Valid++
+	}
+}
+
+// verifyInclusion checks the record's inclusion proof against the root of its
+// batch attestation and the root's signature.
+func (c *attestationCache) verifyInclusion(ctx context.Context, record *models.Record) error {
+	attestation, err := c.get(ctx, *record.AttestationID)
+	if err != nil {
+		return err
+	}
+
+	if record.LeafIndex == nil {
+		return errors.New("record has no leaf index")
+	}
+
+	proof, err := merkle.DecodeProof(record.InclusionProof)
+	if err != nil {
+		return err
+	}
+
+	payload, err := canonical.Apply(canonical.Scheme(attestation.Canonicalization), record.Payload)
+	if err != nil {
+		return err
+	}
+
+	return merkle.VerifyInclusion(*record.LeafIndex, attestation.TreeSize, merkle.LeafHash(payload), proof, attestation.RootHash)
+}
+
+func (c *attestationCache) get(ctx context.Context, id int) (*models.BatchAttestation, error) {
+	if entry, ok := c.entries[id]; ok {
+		return entry.attestation, entry.err
+	}
+
+	attestation, err := c.database.GetBatchAttestation(ctx, id)
+	if err == nil && attestation == nil {
+		err = fmt.Errorf("attestation %d not found", id)
+	}
+	if err == nil {
+		key, ok := c.keys[attestation.KeyID]
+		if !ok {
+			err = fmt.Errorf("attestation %d is signed by unknown key %d", id, attestation.KeyID)
+		} else if key.State == models.KeyStateRevoked {
+			err = fmt.Errorf("attestation %d is signed by revoked key %d", id, attestation.KeyID)
+		} else if verifyErr := crypto.Verify(crypto.Algorithm(key.Algorithm), key.PublicKey, attestation.RootHash, attestation.Signature); verifyErr != nil {
+			err = fmt.Errorf("attestation %d root signature: %w", id, verifyErr)
+		}
+	}
+
+	c.entries[id] = attestationEntry{attestation: attestation, err: err}
+	return attestation, err
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("expected RFC 3339 time: %w", err)
+	}
+
+	return &t, nil
+}
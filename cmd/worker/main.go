@@ -2,20 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/internal/metrics"
+	"github.com/arleyar/go-record-signer/pkg/canonical"
 	"github.com/arleyar/go-record-signer/pkg/config"
 	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/keyservice"
+	"github.com/arleyar/go-record-signer/pkg/merkle"
 	"github.com/arleyar/go-record-signer/pkg/messaging"
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"github.com/google/uuid"
+)
+
+// Records not written are counted as conflicts; a rising count means
+// batches are being delivered more than once.
+var (
+	recordsWritten = expvar.NewInt("worker_records_written")
+	alreadySigned  = expvar.NewInt("worker_records_already_signed")
+	wrongState     = expvar.NewInt("worker_records_wrong_state")
+	conflicts      = expvar.NewInt("worker_write_conflicts")
 )
 
 func main() {
 	log.Println("Starting Record Worker")
 
 	cfg := config.LoadConfig()
+	metrics.Serve(cfg.MetricsAddr)
 
 	database, err := db.New(cfg)
 	if err != nil {
@@ -31,22 +51,55 @@ func main() {
 
 	log.Printf("Connected to database and NATS")
 
-	key, err := cfg.GetEncryptionKey()
+	signers, err := newSignerSource(cfg)
 	if err != nil {
-		log.Fatalf("Failed to get encryption key: %v", err)
+		log.Fatalf("Failed to create signer backend: %v", err)
 	}
 
-	encryptor, err := crypto.NewKeyEncryptor(key)
+	scheme, err := canonical.ParseScheme(cfg.Canonicalization)
 	if err != nil {
-		log.Fatalf("Failed to create key encryptor: %v", err)
+		log.Fatalf("Invalid canonicalization scheme: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	sub, err := natsClient.SubscribeBatch(func(ctx context.Context, batch *messaging.BatchMessage) error {
-		return processBatch(ctx, database, encryptor, batch)
-	})
+	if cfg.MaxAttempts <= 0 {
+		log.Fatalf("MAX_ATTEMPTS must be positive, got %d", cfg.MaxAttempts)
+	}
+
+	if cfg.KeyLeaseTTL <= 0 {
+		log.Fatalf("KEY_LEASE_TTL must be positive, got %s", cfg.KeyLeaseTTL)
+	}
+
+	weights, err := db.ParseKeyWeights(cfg.KeySelectionWeights)
+	if err != nil {
+		log.Fatalf("Invalid KEY_SELECTION_WEIGHTS: %v", err)
+	}
+
+	selector, err := db.NewKeySelector(cfg.KeySelection, weights)
+	if err != nil {
+		log.Fatalf("Failed to create key selector: %v", err)
+	}
+
+	id := workerID(cfg)
+	keys, err := database.NewKeyLocker(cfg.KeyLockStrategy, selector, id, cfg.KeyLeaseTTL)
+	if err != nil {
+		log.Fatalf("Failed to create key locker: %v", err)
+	}
+	log.Printf("Worker %s selecting keys by %s and holding them with the %s strategy", id, selector.Name(), cfg.KeyLockStrategy)
+
+	w := &worker{
+		database:    database,
+		keys:        keys,
+		signers:     signers,
+		scheme:      scheme,
+		attest:      cfg.BatchAttestation,
+		heartbeat:   cfg.KeyLeaseTTL / 3,
+		maxAttempts: cfg.MaxAttempts,
+	}
+
+	sub, err := natsClient.SubscribeBatch(w.handleBatch)
 
 	if err != nil {
 		log.Fatalf("Failed to subscribe to NATS: %v", err)
@@ -61,37 +114,452 @@ func main() {
 	log.Printf("Record Worker is finished!")
 }
 
-func processBatch(ctx context.Context, db *db.DB, crypto *crypto.KeyEncryptor, batch *messaging.BatchMessage) error {
+// worker signs batches with keys held through keys, checking every
+// heartbeat that each key it signs with is still held.
+type worker struct {
+	database  *db.DB
+	keys      db.KeyLocker
+	signers   crypto.SignerSource
+	scheme    canonical.Scheme
+	attest    bool
+	heartbeat time.Duration
+	// maxAttempts is how many failed batches a record goes through before
+	// it is parked as FAILED.
+	maxAttempts int
+}
+
+// handleBatch processes a batch and counts each failure against the records
+// it hit: every record of the batch if the batch as a whole failed, otherwise
+// only the records processBatch isolated. The message is redelivered while
+// any of them may still be retried and acknowledged once all of them are
+// signed or parked as FAILED. Running out of free keys, losing a key lock, or
+// a key revoked or over its quota while signing is not the batch's fault and
+// is not counted.
+func (w *worker) handleBatch(ctx context.Context, batch *messaging.BatchMessage) error {
+	rejected, err := w.processBatch(ctx, batch)
+	if errors.Is(err, db.ErrNoAvailableKey) || errors.Is(err, db.ErrLeaseLost) ||
+		errors.Is(err, db.ErrKeyRevoked) || errors.Is(err, db.ErrQuotaExceeded) {
+		return err
+	}
+
+	if err != nil {
+		rejected = make(map[int]error, len(batch.Records))
+		for _, id := range batchRecordIDs(batch) {
+			rejected[id] = err
+		}
+	} else if len(rejected) == 0 {
+		return nil
+	} else {
+		err = fmt.Errorf("%d records of batch %s failed", len(rejected), batch.BatchID)
+	}
+
+	// Records failing for the same reason are counted in one update.
+	byReason := make(map[string][]int)
+	for id, recordErr := range rejected {
+		byReason[recordErr.Error()] = append(byReason[recordErr.Error()], id)
+	}
+
+	parked, waiting := 0, 0
+	for reason, ids := range byReason {
+		p, wt, recordErr := w.database.RecordBatchFailure(ctx, batch.BatchID, ids, reason, w.maxAttempts)
+		if recordErr != nil {
+			log.Printf("Failed to record failure of batch %s: %v", batch.BatchID, recordErr)
+			return err
+		}
+		parked += p
+		waiting += wt
+	}
+
+	if parked > 0 {
+		log.Printf("Parked %d records of batch %s as FAILED after %d attempts: %v", parked, batch.BatchID, w.maxAttempts, err)
+	}
+
+	if waiting == 0 {
+		return nil
+	}
+
+	return err
+}
+
+// processBatch signs a batch and stores its signatures. A record that fails
+// on its own, because its payload cannot be canonicalized or its signatures
+// cannot be written, is left out and returned with its error, so one bad
+// record does not hold back the rest of the batch. An error is returned only
+// when the batch as a whole failed and nothing was stored.
+func (w *worker) processBatch(ctx context.Context, batch *messaging.BatchMessage) (map[int]error, error) {
 	log.Printf("Processing batch %s with %d records", batch.BatchID, len(batch.Records))
 
-	key, err := db.GetLeastRecentlyUsedKey(ctx)
+	// Records parked as FAILED stay parked until they are retried by hand,
+	// even if a redelivered batch still carries them.
+	statuses, err := w.database.GetRecordStatuses(ctx, batchRecordIDs(batch))
 	if err != nil {
-		return fmt.Errorf("failed to get signing key: %w", err)
+		return nil, fmt.Errorf("failed to get record statuses: %w", err)
+	}
+
+	var records []models.RecordMessage
+	for _, record := range batch.Records {
+		if statuses[record.ID] != models.RecordStatusFailed {
+			records = append(records, record)
+		}
+	}
+
+	rejected := make(map[int]error)
+
+	ids := make([]int, 0, len(records))
+	payloads := make(map[int][]byte, len(records))
+	for _, record := range records {
+		payload, err := canonical.Apply(w.scheme, record.Payload)
+		if err != nil {
+			// The reason is stored on the record, so it leaves out the
+			// record ID and records failing alike share one update.
+			rejected[record.ID] = fmt.Errorf("failed to canonicalize payload: %w", err)
+			log.Printf("Leaving record %d out of batch %s: %v", record.ID, batch.BatchID, rejected[record.ID])
+			continue
+		}
+		ids = append(ids, record.ID)
+		payloads[record.ID] = payload
 	}
+	sort.Ints(ids)
+
+	// A redelivered batch may already carry some signatures; only the
+	// missing ones are added, never from a key that signed the record before.
+	signedBy, err := w.database.GetRecordSignatureKeys(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing signatures: %w", err)
+	}
+
+	remaining := make(map[int]int, len(records))
+	for _, record := range records {
+		if _, ok := payloads[record.ID]; !ok {
+			continue
+		}
+		required := max(record.SignaturesRequired, 1)
+		if missing := required - len(signedBy[record.ID]); missing > 0 {
+			remaining[record.ID] = missing
+		}
+	}
+
+	var signatures []*models.RecordSignature
+	var attestation *models.BatchAttestation
+	var proofs map[int]db.RecordProof
 
-	defer func() {
-		if releaseErr := db.ReleaseKey(ctx, key.ID); releaseErr != nil {
-			log.Printf("Failed to release key %d: %v", key.ID, releaseErr)
+	// Keys whose quota ran out part-way through this batch; their new count
+	// is only stored with the batch, so the database cannot skip them yet.
+	exhausted := make(map[int]bool)
+
+	// Each round holds a single key, so co-signing never uses two keys at
+	// once and every key is released before the next one is acquired.
+	for len(remaining) > 0 {
+		err := w.signWithNextKey(ctx, excludedKeys(signedBy, remaining, exhausted), func(ctx context.Context, key *models.SigningKey, signer crypto.Signer) error {
+			log.Printf("Using key %d to sign %d records of batch %s", key.ID, len(remaining), batch.BatchID)
+
+			budget := key.RemainingSignatures()
+			for _, id := range ids {
+				if remaining[id] == 0 {
+					continue
+				}
+
+				// Stop as soon as the lease is lost; nothing from this
+				// batch is committed then.
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+
+				if budget == 0 {
+					log.Printf("Key %d reached its signature quota, continuing batch %s with another key", key.ID, batch.BatchID)
+					exhausted[key.ID] = true
+					break
+				}
+				if budget > 0 {
+					budget--
+				}
+
+				signature, err := signer.Sign(payloads[id])
+				if err != nil {
+					return fmt.Errorf("failed to sign record %d: %w", id, err)
+				}
+
+				signatures = append(signatures, &models.RecordSignature{RecordID: id, KeyID: key.ID, Signature: signature})
+				signedBy[id] = append(signedBy[id], key.ID)
+				if remaining[id]--; remaining[id] == 0 {
+					delete(remaining, id)
+				}
+			}
+
+			if w.attest && attestation == nil {
+				var err error
+				attestation, proofs, err = attestBatch(signer, key.ID, w.scheme, batch.BatchID, payloads)
+				if err != nil {
+					return fmt.Errorf("failed to attest batch %s: %w", batch.BatchID, err)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	result := &db.SignatureResult{}
+	if err := w.storeSignatures(ctx, batch.BatchID, ids, signatures, attestation, proofs, result, rejected); err != nil {
+		return nil, fmt.Errorf("failed to update record signatures: %w", err)
+	}
+
+	recordsWritten.Add(int64(len(result.Written)))
+	alreadySigned.Add(int64(len(result.AlreadySigned)))
+	wrongState.Add(int64(len(result.WrongState)))
+	conflicts.Add(int64(result.Conflicts()))
+
+	if result.Conflicts() > 0 {
+		log.Printf("Batch %s was not written for every record, it was likely delivered more than once: already signed %v, in wrong state %v",
+			batch.BatchID, result.AlreadySigned, result.WrongState)
+	}
+
+	log.Printf("Processed batch %s with %d records and %d signatures: %d written, %d already signed, %d in wrong state, %d left out",
+		batch.BatchID, len(batch.Records), len(signatures), len(result.Written), len(result.AlreadySigned), len(result.WrongState), len(rejected))
+
+	return rejected, nil
+}
+
+// storeSignatures writes the signatures of the records in ids and adds the
+// outcome to result. If the write fails with a data error, which a single
+// record can cause, ids is split in half and each half is written on its
+// own, until every record that cannot be written is isolated and added to
+// rejected. Any other error fails the whole batch.
+func (w *worker) storeSignatures(ctx context.Context, batchID string, ids []int, signatures []*models.RecordSignature, attestation *models.BatchAttestation, proofs map[int]db.RecordProof, result *db.SignatureResult, rejected map[int]error) error {
+	stored, err := w.database.UpdateRecordSignatures(ctx, batchID, ids, recordSignatures(signatures, ids), string(w.scheme), attestation, proofs)
+	if err == nil {
+		result.Add(stored)
+		return nil
+	}
+	if ctx.Err() != nil || !db.IsDataError(err) {
+		return err
+	}
+
+	if len(ids) == 1 {
+		rejected[ids[0]] = err
+		log.Printf("Leaving record %d out of the batch: %v", ids[0], err)
+		return nil
+	}
+
+	half := len(ids) / 2
+	if err := w.storeSignatures(ctx, batchID, ids[:half], signatures, attestation, proofs, result, rejected); err != nil {
+		return err
+	}
+	return w.storeSignatures(ctx, batchID, ids[half:], signatures, attestation, proofs, result, rejected)
+}
+
+// signWithNextKey locks the next key outside exclude, calls
+// sign with it and releases the key again. The lock is renewed in the
+// background while sign runs; if it is lost, the context passed to sign is
+// cancelled and an error wrapping db.ErrLeaseLost is returned, so signatures
+// made under the lock are never committed.
+func (w *worker) signWithNextKey(ctx context.Context, exclude []int, sign func(ctx context.Context, key *models.SigningKey, signer crypto.Signer) error) error {
+	lock, err := w.keys.AcquireKey(ctx, exclude)
+	if err != nil {
+		return fmt.Errorf("failed to get signing key: %w", err)
+	}
+	key := lock.Key()
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		w.renewLease(leaseCtx, cancel, lock)
 	}()
 
-	log.Printf("Using key %d to sign batch %s", key.ID, batch.BatchID)
+	err = w.withSigner(leaseCtx, key, sign)
 
-	signatures := make(map[int][]byte, len(batch.Records))
-	for _, record := range batch.Records {
-		signature, err := crypto.SignPayload(key.PrivateKey, record.Payload)
+	cancel(nil)
+	<-heartbeat
+
+	if cause := context.Cause(leaseCtx); errors.Is(cause, db.ErrLeaseLost) {
+		// The lock is released anyway to free what it still holds, such
+		// as an advisory lock's connection.
+		if releaseErr := lock.Release(ctx); releaseErr != nil && !errors.Is(releaseErr, db.ErrLeaseLost) {
+			log.Printf("Failed to release lost key %d: %v", key.ID, releaseErr)
+		}
+		return cause
+	}
+
+	if releaseErr := lock.Release(ctx); releaseErr != nil {
+		if errors.Is(releaseErr, db.ErrLeaseLost) || err == nil {
+			return releaseErr
+		}
+		log.Printf("Failed to release key %d: %v", key.ID, releaseErr)
+	}
+
+	return err
+}
+
+func (w *worker) withSigner(ctx context.Context, key *models.SigningKey, sign func(ctx context.Context, key *models.SigningKey, signer crypto.Signer) error) error {
+	signer, err := w.signers.OpenSigner(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to open signing key %d: %w", key.ID, err)
+	}
+	defer signer.Destroy()
+
+	return sign(ctx, key, signer)
+}
+
+// renewLease renews lock every heartbeat until ctx is done, cancelling ctx
+// with the error if the lock is lost.
+func (w *worker) renewLease(ctx context.Context, cancel context.CancelCauseFunc, lock db.KeyLock) {
+	keyID := lock.Key().ID
+	ticker := time.NewTicker(w.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := lock.Renew(ctx)
+			if errors.Is(err, db.ErrLeaseLost) {
+				log.Printf("Lost lease on key %d: %v", keyID, err)
+				cancel(err)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to renew lease on key %d: %v", keyID, err)
+			}
+		}
+	}
+}
+
+func batchRecordIDs(batch *messaging.BatchMessage) []int {
+	ids := make([]int, len(batch.Records))
+	for i, record := range batch.Records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// recordSignatures returns the signatures of the records in ids.
+func recordSignatures(signatures []*models.RecordSignature, ids []int) []*models.RecordSignature {
+	wanted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var selected []*models.RecordSignature
+	for _, signature := range signatures {
+		if wanted[signature.RecordID] {
+			selected = append(selected, signature)
+		}
+	}
+	return selected
+}
+
+// excludedKeys returns every key that has signed a record still missing
+// signatures, plus the keys exhausted during this batch.
+func excludedKeys(signedBy map[int][]int, remaining map[int]int, exhausted map[int]bool) []int {
+	seen := make(map[int]bool)
+	var keys []int
+	for keyID := range exhausted {
+		seen[keyID] = true
+		keys = append(keys, keyID)
+	}
+	for id := range remaining {
+		for _, keyID := range signedBy[id] {
+			if !seen[keyID] {
+				seen[keyID] = true
+				keys = append(keys, keyID)
+			}
+		}
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// attestBatch builds a Merkle tree over the canonical payloads ordered by
+// record ID and signs its root, returning each record's inclusion proof.
+func attestBatch(signer crypto.Signer, keyID int, scheme canonical.Scheme, batchID string, payloads map[int][]byte) (*models.BatchAttestation, map[int]db.RecordProof, error) {
+	ids := make([]int, 0, len(payloads))
+	for id := range payloads {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	leaves := make([][]byte, len(ids))
+	for i, id := range ids {
+		leaves[i] = payloads[id]
+	}
+
+	tree, err := merkle.New(leaves)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := tree.Root()
+	signature, err := signer.Sign(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign Merkle root: %w", err)
+	}
+
+	proofs := make(map[int]db.RecordProof, len(ids))
+	for i, id := range ids {
+		proof, err := tree.Proof(i)
 		if err != nil {
-			return fmt.Errorf("failed to sign record %d: %w", record.ID, err)
+			return nil, nil, err
 		}
-		signatures[record.ID] = signature
+		proofs[id] = db.RecordProof{LeafIndex: i, Proof: merkle.EncodeProof(proof)}
+	}
+
+	attestation := &models.BatchAttestation{
+		BatchID:          batchID,
+		KeyID:            keyID,
+		RootHash:         root,
+		TreeSize:         tree.Size(),
+		Signature:        signature,
+		Canonicalization: string(scheme),
+	}
+
+	return attestation, proofs, nil
+}
+
+// workerID names the worker as the holder of its key leases: WORKER_ID if
+// set, otherwise the host name and process ID with a random suffix, so a
+// restarted worker never mistakes an old lease for its own.
+func workerID(cfg *config.Config) string {
+	if cfg.WorkerID != "" {
+		return cfg.WorkerID
 	}
 
-	if err := db.UpdateRecordSignatures(ctx, signatures, key.ID); err != nil {
-		return fmt.Errorf("failed to update record signatures: %w", err)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
 	}
 
-	log.Printf("Successfully processed batch %s with %d records using key %d",
-		batch.BatchID, len(batch.Records), key.ID)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8])
+}
+
+// newSignerSource returns the backend selected by SIGNER_BACKEND: "local"
+// unwraps keys from the database in-process, "remote" signs through the key
+// server at KEYSERVER_URL and "agent" through the key agent socket at
+// KEYAGENT_SOCKET, so private keys never enter the worker.
+func newSignerSource(cfg *config.Config) (crypto.SignerSource, error) {
+	switch cfg.SignerBackend {
+	case "local":
+		keys, err := cfg.GetEncryptionKeys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get encryption keys: %w", err)
+		}
 
-	return nil
+		encryptor, err := crypto.NewVersionedKeyEncryptor(keys, cfg.EncryptionKeyVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create key encryptor: %w", err)
+		}
+
+		return encryptor, nil
+	case "remote":
+		log.Printf("Signing through key server at %s", cfg.KeyServerURL)
+		return keyservice.NewClient(cfg.KeyServerURL, cfg.KeyServerToken), nil
+	case "agent":
+		log.Printf("Signing through key agent at %s", cfg.KeyAgentSocket)
+		return keyservice.NewUnixClient(cfg.KeyAgentSocket, cfg.KeyServerToken), nil
+	default:
+		return nil, fmt.Errorf("unknown signer backend %q", cfg.SignerBackend)
+	}
 }
@@ -2,17 +2,36 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/arleyar/go-record-signer/pkg/config"
 	"github.com/arleyar/go-record-signer/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	ErrNoAvailableKey       = errors.New("no available signing keys found")
+	ErrInvalidKeyTransition = errors.New("invalid key state transition")
+	ErrQuotaExceeded        = errors.New("signature quota exceeded")
+	ErrLeaseLost            = errors.New("key lease lost")
+	ErrKeyRevoked           = errors.New("signing key revoked")
+)
+
+// keyFree matches keys that are not held under a live lease. A key whose
+// holder stopped renewing its lease becomes free again once leased_until
+// passes, so a crashed worker cannot take a key out of the pool for good.
+// Keys held without an expiry (leased_until NULL) stay held until released.
+const keyFree = "(in_use = false OR leased_until <= ?)"
+
 type DB struct {
 	gorm *gorm.DB
 }
@@ -46,21 +65,131 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) CreateTables() error {
-	err := db.gorm.AutoMigrate(&models.SigningKey{}, &models.Record{})
+	migrator := db.gorm.Migrator()
+	backfillCounts := migrator.HasTable(&models.SigningKey{}) && !migrator.HasColumn(&models.SigningKey{}, "signature_count")
+
+	err := db.gorm.AutoMigrate(&models.SigningKey{}, &models.Record{}, &models.RecordSignature{}, &models.BatchAttestation{}, &models.KeyAuditEvent{}, &models.OutboxMessage{})
 	if err != nil {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
+
+	if err := db.migrateLegacySignatures(); err != nil {
+		return fmt.Errorf("failed to migrate record signatures: %w", err)
+	}
+
+	// Claims look for PENDING records in ID order; the partial index holds
+	// only those, so it stays small however many records have been queued.
+	err = db.gorm.Exec("CREATE INDEX IF NOT EXISTS idx_records_pending ON records (id) WHERE " + pendingPredicate).Error
+	if err != nil {
+		return fmt.Errorf("failed to create pending records index: %w", err)
+	}
+
+	if backfillCounts {
+		err := db.gorm.Exec(`
+			UPDATE signing_keys SET signature_count =
+				(SELECT count(*) FROM record_signatures WHERE key_id = signing_keys.id)`).Error
+		if err != nil {
+			return fmt.Errorf("failed to backfill signature counts: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func (db *DB) InsertSigningKeys(keys []*models.SigningKey) error {
+// migrateLegacySignatures moves signatures from the old records.signature and
+// records.signed_by columns into record_signatures and drops the columns.
+func (db *DB) migrateLegacySignatures() error {
+	if !db.gorm.Migrator().HasColumn(&models.Record{}, "signature") {
+		return nil
+	}
+
+	return db.gorm.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO record_signatures (record_id, key_id, signature, signed_at)
+			SELECT id, signed_by, signature, COALESCE(signed_at, now())
+			FROM records
+			WHERE signature IS NOT NULL AND signed_by IS NOT NULL AND signed_by <> 0
+			ON CONFLICT (record_id, key_id) DO NOTHING`).Error
+		if err != nil {
+			return err
+		}
+
+		for _, column := range []string{"signature", "signed_by"} {
+			if err := tx.Migrator().DropColumn(&models.Record{}, column); err != nil {
+				return err
+			}
+		}
+
+		log.Println("Migrated legacy record signatures to record_signatures")
+		return nil
+	})
+}
+
+// InsertSigningKeys stores keys and then calls wrap on each one so the
+// private key can be encrypted with the key's database ID bound in. Both
+// steps run in one transaction, so no row is ever left without key material.
+func (db *DB) InsertSigningKeys(keys []*models.SigningKey, wrap func(key *models.SigningKey) error, actor string) error {
 	if len(keys) == 0 {
 		return nil
 	}
 
-	result := db.gorm.Create(keys)
+	err := db.gorm.Transaction(func(tx *gorm.DB) error {
+		return insertSigningKeys(tx, keys, wrap, actor, "initial pool")
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to insert signing keys: %w", err)
+	}
+
+	return nil
+}
+
+func insertSigningKeys(tx *gorm.DB, keys []*models.SigningKey, wrap func(key *models.SigningKey) error, actor string, reason string) error {
+	for _, key := range keys {
+		if key.PrivateKey == nil {
+			key.PrivateKey = []byte{}
+		}
+	}
+
+	if result := tx.Create(keys); result.Error != nil {
+		return result.Error
+	}
+
+	for _, key := range keys {
+		if err := wrap(key); err != nil {
+			return fmt.Errorf("key %d: %w", key.ID, err)
+		}
+
+		result := tx.Model(key).
+			Updates(map[string]interface{}{
+				"private_key": key.PrivateKey,
+				"kek_version": key.KEKVersion,
+				"wrap_format": key.WrapFormat,
+			})
+
+		if result.Error != nil {
+			return fmt.Errorf("key %d: %w", key.ID, result.Error)
+		}
+
+		if err := insertKeyEvent(tx, key.ID, models.KeyEventCreated, actor, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertKeyEvent(tx *gorm.DB, keyID int, event models.KeyEventType, actor string, reason string) error {
+	result := tx.Create(&models.KeyAuditEvent{
+		KeyID:     keyID,
+		Event:     event,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+
 	if result.Error != nil {
-		return fmt.Errorf("failed to insert signing keys: %w", result.Error)
+		return fmt.Errorf("failed to record %s event for key %d: %w", event, keyID, result.Error)
 	}
 
 	return nil
@@ -83,52 +212,957 @@ func (db *DB) InsertRecords(records []*models.Record) error {
 	return nil
 }
 
-func (db *DB) GetPendingRecords(ctx context.Context, batchSize int) ([]*models.Record, error) {
+// ClaimPendingRecords moves up to batchSize PENDING records to QUEUED under
+// a new batch ID and stores the outbox message that message builds for that
+// batch, both in one
+// transaction, and returns the records in ID order. The outbox relay
+// publishes the message afterwards, so a record is QUEUED exactly when its
+// batch is bound to be published.
+//
+// The candidate rows are locked with FOR UPDATE SKIP LOCKED inside the same
+// UPDATE, so concurrent dispatchers always claim disjoint sets of records
+// and no record is published twice.
+func (db *DB) ClaimPendingRecords(ctx context.Context, batchSize int, message func(batchID string, records []*models.Record) (*models.OutboxMessage, error)) ([]*models.Record, error) {
+	return db.claimPendingRecords(ctx, 0, batchSize, message)
+}
+
+// pendingPredicate selects PENDING records. It is written into queries as a
+// literal rather than a parameter, since a generic plan for a prepared
+// statement can only use a partial index whose predicate it matches
+// literally.
+const pendingPredicate = "status = 'PENDING'"
+
+// claimPendingRecords claims PENDING records with IDs above afterID, as
+// ClaimPendingRecords does.
+// pendingRecordIDs selects the IDs of the next batchSize PENDING records
+// after afterID, skipping rows locked by other claims.
+func pendingRecordIDs(tx *gorm.DB, afterID int, batchSize int) *gorm.DB {
+	return tx.Model(&models.Record{}).
+		Select("id").
+		Where(pendingPredicate+" AND id > ?", afterID).
+		Order("id").
+		Limit(batchSize).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+}
+
+func (db *DB) claimPendingRecords(ctx context.Context, afterID int, batchSize int, message func(batchID string, records []*models.Record) (*models.OutboxMessage, error)) ([]*models.Record, error) {
+	var records []*models.Record
+	batchID := uuid.NewString()
+
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		pending := pendingRecordIDs(tx, afterID, batchSize)
+
+		result := tx.Model(&records).
+			Clauses(clause.Returning{}).
+			Where("id IN (?)", pending).
+			Updates(map[string]interface{}{
+				"status":    models.RecordStatusQueued,
+				"queued_at": time.Now(),
+				"batch_id":  batchID,
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].ID < records[j].ID
+		})
+
+		msg, err := message(batchID, records)
+		if err != nil {
+			return err
+		}
+
+		return insertOutboxMessage(tx, msg)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending records: %w", err)
+	}
+
+	return records, nil
+}
+
+// RequeueStaleRecords moves up to limit records that have been QUEUED since
+// before the given time back to PENDING, so a dispatcher publishes them
+// again, and returns them with the time they were queued. Records queued
+// before queued_at existed count as stale.
+func (db *DB) RequeueStaleRecords(ctx context.Context, queuedBefore time.Time, limit int) ([]*models.Record, error) {
 	var records []*models.Record
 
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Select("id", "queued_at").
+			Where("status = ?", models.RecordStatusQueued).
+			Where("(queued_at IS NULL OR queued_at < ?)", queuedBefore).
+			Order("id").
+			Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Find(&records)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		ids := make([]int, len(records))
+		for i, record := range records {
+			ids[i] = record.ID
+		}
+
+		return tx.Model(&models.Record{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":    models.RecordStatusPending,
+				"queued_at": nil,
+				"batch_id":  "",
+			}).Error
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue stale records: %w", err)
+	}
+
+	return records, nil
+}
+
+// GetRecordStatuses returns the current status of each of the given records.
+func (db *DB) GetRecordStatuses(ctx context.Context, recordIDs []int) (map[int]models.RecordStatus, error) {
+	statuses := make(map[int]models.RecordStatus, len(recordIDs))
+	if len(recordIDs) == 0 {
+		return statuses, nil
+	}
+
+	var records []models.Record
+
 	result := db.gorm.WithContext(ctx).
-		Where("status = ?", models.RecordStatusPending).
+		Select("id", "status").
+		Where("id IN ?", recordIDs).
+		Find(&records)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query record statuses: %w", result.Error)
+	}
+
+	for _, record := range records {
+		statuses[record.ID] = record.Status
+	}
+
+	return statuses, nil
+}
+
+// RecordBatchFailure counts a failed signing attempt against every record
+// in recordIDs still QUEUED for batchID and stores reason as its last
+// error. Records that reach maxAttempts are parked as FAILED. It returns how
+// many records were parked and how many are still waiting to be signed.
+func (db *DB) RecordBatchFailure(ctx context.Context, batchID string, recordIDs []int, reason string, maxAttempts int) (int, int, error) {
+	var records []models.Record
+
+	result := db.gorm.WithContext(ctx).
+		Model(&records).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "status"}}}).
+		Where("id IN ?", recordIDs).
+		Where("status = ? AND batch_id = ?", models.RecordStatusQueued, batchID).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": reason,
+			"status":     gorm.Expr("CASE WHEN attempts + 1 >= ? THEN ? ELSE status END", maxAttempts, models.RecordStatusFailed),
+		})
+
+	if result.Error != nil {
+		return 0, 0, fmt.Errorf("failed to record batch failure: %w", result.Error)
+	}
+
+	parked, waiting := 0, 0
+	for _, record := range records {
+		if record.Status == models.RecordStatusFailed {
+			parked++
+		} else {
+			waiting++
+		}
+	}
+
+	return parked, waiting, nil
+}
+
+// GetFailedRecords returns up to limit FAILED records with IDs above afterID.
+func (db *DB) GetFailedRecords(ctx context.Context, afterID int, limit int) ([]*models.Record, error) {
+	var records []*models.Record
+
+	result := db.gorm.WithContext(ctx).
+		Where("status = ? AND id > ?", models.RecordStatusFailed, afterID).
 		Order("id").
-		Limit(batchSize).
+		Limit(limit).
 		Find(&records)
 
 	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query pending records: %w", result.Error)
+		return nil, fmt.Errorf("failed to query failed records: %w", result.Error)
 	}
 
 	return records, nil
 }
 
-func (db *DB) UpdateRecordsToQueued(ctx context.Context, records []*models.Record) error {
-	if len(records) == 0 {
+// RetryFailedRecords moves the given FAILED records, or every FAILED record
+// if recordIDs is empty, back to PENDING with their attempts reset, and
+// returns how many were moved.
+func (db *DB) RetryFailedRecords(ctx context.Context, recordIDs []int) (int64, error) {
+	query := db.gorm.WithContext(ctx).
+		Model(&models.Record{}).
+		Where("status = ?", models.RecordStatusFailed)
+	if len(recordIDs) > 0 {
+		query = query.Where("id IN ?", recordIDs)
+	}
+
+	result := query.Updates(map[string]interface{}{
+		"status":     models.RecordStatusPending,
+		"attempts":   0,
+		"last_error": "",
+		"queued_at":  nil,
+		"batch_id":   "",
+	})
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to retry failed records: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// GetLeastRecentlyUsedKey acquires a key with the LRU selector; see
+// AcquireSigningKey.
+func (db *DB) GetLeastRecentlyUsedKey(ctx context.Context, owner string, ttl time.Duration, exclude []int) (*models.SigningKey, error) {
+	selector, _ := NewKeySelector(KeySelectLRU, nil)
+	return db.AcquireSigningKey(ctx, selector, owner, ttl, exclude)
+}
+
+// AcquireSigningKey leases the free key that selector ranks first among
+// those that are active, inside their validity window and under their
+// signature quota, skipping the keys in exclude. The lease is held by owner
+// for ttl and must be kept alive with RenewKeyLease; a ttl of zero holds the
+// key until ReleaseKey. Keys whose lease expired are reclaimed, and free
+// keys that have used up their quota are retired on the way.
+//
+// The candidate row is locked with FOR UPDATE SKIP LOCKED, so concurrent
+// callers never read the same free key: each one locks a different row or
+// finds none. The claiming UPDATE re-checks that the key is free as a guard.
+func (db *DB) AcquireSigningKey(ctx context.Context, selector KeySelector, owner string, ttl time.Duration, exclude []int) (*models.SigningKey, error) {
+	var key *models.SigningKey
+
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		key, err = acquireKey(tx, selector, owner, ttl, exclude, time.Now())
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire %s key: %w", selector.Name(), err)
+	}
+
+	return key, nil
+}
+
+func acquireKey(tx *gorm.DB, selector KeySelector, owner string, ttl time.Duration, exclude []int, now time.Time) (*models.SigningKey, error) {
+	var key models.SigningKey
+
+	if err := retireExhaustedKeys(tx, now); err != nil {
+		return nil, err
+	}
+
+	result := usableKeys(tx, now, exclude).
+		Where(keyFree, now).
+		Clauses(selector.OrderBy(), clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Limit(1).
+		Find(&key)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNoAvailableKey
+	}
+
+	if key.InUse {
+		reason := expiredLeaseReason(&key)
+		if err := insertKeyEvent(tx, key.ID, models.KeyEventReclaimed, owner, reason); err != nil {
+			return nil, err
+		}
+		log.Printf("Reclaimed key %d: %s", key.ID, reason)
+	}
+
+	var leasedUntil *time.Time
+	if ttl > 0 {
+		until := now.Add(ttl)
+		leasedUntil = &until
+	}
+
+	result = tx.Model(&key).
+		Where(keyFree, now).
+		Updates(map[string]interface{}{
+			"in_use":       true,
+			"last_used":    now,
+			"leased_by":    owner,
+			"leased_until": leasedUntil,
+		})
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNoAvailableKey
+	}
+
+	key.LastUsed = &now
+	key.InUse = true
+	key.LeasedBy = owner
+	key.LeasedUntil = leasedUntil
+
+	return &key, nil
+}
+
+// usableKeys selects the keys outside exclude that may sign at now: active,
+// inside their validity window and under their quota.
+func usableKeys(tx *gorm.DB, now time.Time, exclude []int) *gorm.DB {
+	query := tx.
+		Where("state = ?", models.KeyStateActive).
+		Where("(not_before IS NULL OR not_before <= ?)", now).
+		Where("(not_after IS NULL OR not_after > ?)", now).
+		Where("(max_signatures = 0 OR signature_count < max_signatures)")
+	if len(exclude) > 0 {
+		query = query.Where("id NOT IN ?", exclude)
+	}
+	return query
+}
+
+// expiredLeaseReason describes the expired lease a key is reclaimed from.
+func expiredLeaseReason(key *models.SigningKey) string {
+	reason := fmt.Sprintf("lease held by %q expired", key.LeasedBy)
+	if key.LeasedUntil != nil {
+		reason += " at " + key.LeasedUntil.Format(time.RFC3339)
+	}
+	return reason
+}
+
+// retireExhaustedKeys skips rows locked by concurrent acquisitions rather
+// than waiting on them; a later call retires them. A key still marked in use
+// under an expired lease is reclaimed first, with its RECLAIMED event.
+func retireExhaustedKeys(tx *gorm.DB, now time.Time) error {
+	var retired []models.SigningKey
+
+	result := tx.
+		Where("state = ?", models.KeyStateActive).
+		Where(keyFree, now).
+		Where("max_signatures > 0 AND signature_count >= max_signatures").
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Find(&retired)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to find exhausted keys: %w", result.Error)
+	}
+
+	if len(retired) == 0 {
 		return nil
 	}
 
-	ids := make([]int, len(records))
-	for i, record := range records {
-		ids[i] = record.ID
+	ids := make([]int, len(retired))
+	for i, key := range retired {
+		ids[i] = key.ID
 	}
 
-	result := db.gorm.WithContext(ctx).
-		Model(&models.Record{}).
+	result = tx.Model(&models.SigningKey{}).
 		Where("id IN ?", ids).
-		Where("status = ?", models.RecordStatusPending).
-		Update("status", models.RecordStatusQueued)
+		Updates(map[string]interface{}{
+			"state":        models.KeyStateRetired,
+			"retired_at":   now,
+			"in_use":       false,
+			"leased_by":    "",
+			"leased_until": nil,
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to retire exhausted keys: %w", result.Error)
+	}
+
+	for _, key := range retired {
+		if key.InUse {
+			reason := expiredLeaseReason(&key)
+			if err := insertKeyEvent(tx, key.ID, models.KeyEventReclaimed, "quota", reason); err != nil {
+				return err
+			}
+			log.Printf("Reclaimed key %d: %s", key.ID, reason)
+		}
+
+		reason := fmt.Sprintf("signature quota reached after %d signatures", key.SignatureCount)
+		if err := insertKeyEvent(tx, key.ID, models.KeyEventRetired, "quota", reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenewKeyLease extends owner's lease on the key by ttl, or only confirms it
+// is still held if ttl is zero. It returns ErrLeaseLost if the lease already
+// expired or the key was released or reclaimed, in which case owner must
+// stop signing with it.
+func (db *DB) RenewKeyLease(ctx context.Context, keyID int, owner string, ttl time.Duration) error {
+	now := time.Now()
+
+	var leasedUntil *time.Time
+	if ttl > 0 {
+		until := now.Add(ttl)
+		leasedUntil = &until
+	}
+
+	result := db.gorm.WithContext(ctx).
+		Model(&models.SigningKey{}).
+		Where("id = ? AND in_use = ? AND leased_by = ?", keyID, true, owner).
+		Where("(leased_until IS NULL OR leased_until > ?)", now).
+		Update("leased_until", leasedUntil)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to renew lease on key %d: %w", keyID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: key %d is no longer leased by %s", ErrLeaseLost, keyID, owner)
+	}
+
+	return nil
+}
+
+// ReleaseKey ends owner's lease on the key. It returns ErrLeaseLost if
+// another owner reclaimed the key in the meantime, meaning the key may have
+// been used concurrently and anything signed under the lease must not be
+// committed.
+func (db *DB) ReleaseKey(ctx context.Context, keyID int, owner string) error {
+	result := db.gorm.WithContext(ctx).
+		Model(&models.SigningKey{}).
+		Where("id = ? AND in_use = ? AND leased_by = ?", keyID, true, owner).
+		Updates(map[string]interface{}{
+			"in_use":       false,
+			"leased_by":    "",
+			"leased_until": nil,
+		})
 
 	if result.Error != nil {
-		return fmt.Errorf("failed to update records to queued: %w", result.Error)
+		return fmt.Errorf("failed to release key %d: %w", keyID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: key %d is no longer leased by %s", ErrLeaseLost, keyID, owner)
 	}
 
 	return nil
 }
 
-func (db *DB) GetLeastRecentlyUsedKey(ctx context.Context) (*models.SigningKey, error) {
+// RecordProof places a record in the Merkle tree of a batch attestation.
+type RecordProof struct {
+	LeafIndex int
+	Proof     []byte
+}
+
+// GetRecordSignatureKeys returns the IDs of the keys that have already signed
+// each of the given records.
+func (db *DB) GetRecordSignatureKeys(ctx context.Context, recordIDs []int) (map[int][]int, error) {
+	keys := make(map[int][]int, len(recordIDs))
+	if len(recordIDs) == 0 {
+		return keys, nil
+	}
+
+	var signatures []models.RecordSignature
+
+	result := db.gorm.WithContext(ctx).
+		Select("record_id", "key_id").
+		Where("record_id IN ?", recordIDs).
+		Find(&signatures)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query record signatures: %w", result.Error)
+	}
+
+	for _, signature := range signatures {
+		keys[signature.RecordID] = append(keys[signature.RecordID], signature.KeyID)
+	}
+
+	return keys, nil
+}
+
+// insertKeySignatures stores one key's signatures on the records still
+// QUEUED for batchID and adds the number actually inserted to its
+// signature_count. Signatures for any other record are dropped. The
+// increment fails if it would take the key past its quota or the key was
+// revoked while it was signing, rolling back the whole batch.
+func insertKeySignatures(tx *gorm.DB, keyID int, batchID string, signatures []*models.RecordSignature, signedAt time.Time) error {
+	recordIDs := make(intArray, len(signatures))
+	values := make(byteaArray, len(signatures))
+	for i, signature := range signatures {
+		recordIDs[i] = signature.RecordID
+		values[i] = signature.Signature
+	}
+
+	// The rows are passed as two array parameters, so the statement has
+	// the same size for any number of signatures.
+	result := tx.Exec(`
+		INSERT INTO record_signatures (record_id, key_id, signature, signed_at)
+		SELECT s.record_id, ?, s.signature, ?
+		FROM unnest(?::bigint[], ?::bytea[]) AS s(record_id, signature)
+		JOIN records AS r ON r.id = s.record_id AND r.status = ? AND r.batch_id = ?
+		ORDER BY s.record_id
+		ON CONFLICT (record_id, key_id) DO NOTHING`,
+		keyID, signedAt, recordIDs, values, models.RecordStatusQueued, batchID)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to insert signatures for key %d: %w", keyID, result.Error)
+	}
+
+	inserted := result.RowsAffected
+	if inserted == 0 {
+		return nil
+	}
+
+	result = tx.Model(&models.SigningKey{}).
+		Where("id = ? AND state <> ?", keyID, models.KeyStateRevoked).
+		Where("(max_signatures = 0 OR signature_count + ? <= max_signatures)", inserted).
+		Update("signature_count", gorm.Expr("signature_count + ?", inserted))
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to update signature count for key %d: %w", keyID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		var key models.SigningKey
+		if err := tx.Select("state").Where("id = ?", keyID).Limit(1).Find(&key).Error; err != nil {
+			return fmt.Errorf("failed to get state of key %d: %w", keyID, err)
+		}
+		if key.State == models.KeyStateRevoked {
+			return fmt.Errorf("%w: key %d was revoked while signing", ErrKeyRevoked, keyID)
+		}
+		return fmt.Errorf("%w: key %d cannot take %d more signatures", ErrQuotaExceeded, keyID, inserted)
+	}
+
+	return nil
+}
+
+// IsDataError reports whether err is a Postgres data exception or integrity
+// constraint violation, which the rows being written can cause on their own,
+// so writing fewer of them may succeed. Quota, revocation, connection and
+// context errors are not data errors.
+func IsDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+}
+
+// SignatureResult reports what UpdateRecordSignatures did with each record.
+// A record that was not written usually means the batch was delivered more
+// than once.
+type SignatureResult struct {
+	// Written are the records moved to SIGNED.
+	Written []int
+	// AlreadySigned are the records that were SIGNED before.
+	AlreadySigned []int
+	// WrongState are the remaining records with their status: FAILED,
+	// PENDING again, QUEUED for another batch, still short of signatures,
+	// or missing ("" status).
+	WrongState map[int]models.RecordStatus
+}
+
+// Conflicts returns how many records were not written.
+func (r *SignatureResult) Conflicts() int {
+	return len(r.AlreadySigned) + len(r.WrongState)
+}
+
+// Add merges other into r.
+func (r *SignatureResult) Add(other *SignatureResult) {
+	r.Written = append(r.Written, other.Written...)
+	r.AlreadySigned = append(r.AlreadySigned, other.AlreadySigned...)
+	for id, status := range other.WrongState {
+		if r.WrongState == nil {
+			r.WrongState = make(map[int]models.RecordStatus)
+		}
+		r.WrongState[id] = status
+	}
+}
+
+// UpdateRecordSignatures stores the signatures of batch batchID and marks
+// every record that now has its required number of signatures as SIGNED,
+// reporting the outcome for each record in recordIDs. When attestation is
+// non-nil it is inserted in the same transaction and each signed record is
+// linked to it with its entry from proofs.
+//
+// Only records still QUEUED for batchID are written. A batch delivered
+// after its records were requeued, and possibly claimed by another batch,
+// cannot sign them a second time.
+//
+// A batch may be stored in several calls. The attestation is inserted by the
+// first call that commits, which sets its ID; later calls only link their
+// records to it.
+func (db *DB) UpdateRecordSignatures(ctx context.Context, batchID string, recordIDs []int, signatures []*models.RecordSignature, canonicalization string, attestation *models.BatchAttestation, proofs map[int]RecordProof) (*SignatureResult, error) {
+	result := &SignatureResult{}
+	if len(recordIDs) == 0 {
+		return result, nil
+	}
+
+	now := time.Now()
+
+	// Insert in a stable order to prevent deadlocks
+	sort.Slice(signatures, func(i, j int) bool {
+		if signatures[i].RecordID != signatures[j].RecordID {
+			return signatures[i].RecordID < signatures[j].RecordID
+		}
+		return signatures[i].KeyID < signatures[j].KeyID
+	})
+
+	for _, signature := range signatures {
+		signature.SignedAt = now
+	}
+
+	ids := append(intArray(nil), recordIDs...)
+	sort.Ints(ids)
+
+	tx := db.gorm.WithContext(ctx).Begin()
+
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer tx.Rollback()
+
+	// The records still QUEUED for this batch are locked first, in ID order
+	// to prevent deadlocks, so none of them can be requeued or parked while
+	// their signatures are added. The other records are left alone.
+	var owned []int
+	locked := tx.Raw(`
+		SELECT id FROM records
+		WHERE id = ANY(?::bigint[]) AND status = ? AND batch_id = ?
+		ORDER BY id
+		FOR UPDATE`,
+		ids, models.RecordStatusQueued, batchID).
+		Scan(&owned)
+
+	if locked.Error != nil {
+		return nil, fmt.Errorf("failed to lock batch records: %w", locked.Error)
+	}
+
+	byKey := make(map[int][]*models.RecordSignature)
+	var keyIDs []int
+	for _, signature := range signatures {
+		if _, ok := byKey[signature.KeyID]; !ok {
+			keyIDs = append(keyIDs, signature.KeyID)
+		}
+		byKey[signature.KeyID] = append(byKey[signature.KeyID], signature)
+	}
+	sort.Ints(keyIDs)
+
+	for _, keyID := range keyIDs {
+		if err := insertKeySignatures(tx, keyID, batchID, byKey[keyID], now); err != nil {
+			return nil, err
+		}
+	}
+
+	// The attestation is inserted as a copy, so the caller's ID is only set
+	// once the transaction commits.
+	linked := attestation
+	var inserted *models.BatchAttestation
+	if attestation != nil && attestation.ID == 0 {
+		inserted = &models.BatchAttestation{}
+		*inserted = *attestation
+		inserted.CreatedAt = now
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(inserted)
+		if created.Error != nil {
+			return nil, fmt.Errorf("failed to insert batch attestation: %w", created.Error)
+		}
+
+		// A redelivered batch was already attested, and its records are
+		// already SIGNED, so the update below will not touch them.
+		if created.RowsAffected == 0 {
+			linked, inserted = nil, nil
+		} else {
+			linked = inserted
+		}
+	}
+
+	var attestationID *int
+	var proofIDs, leafIndexes intArray
+	var inclusionProofs byteaArray
+	if linked != nil {
+		attestationID = &linked.ID
+		for _, id := range ids {
+			if proof, ok := proofs[id]; ok {
+				proofIDs = append(proofIDs, id)
+				leafIndexes = append(leafIndexes, proof.LeafIndex)
+				inclusionProofs = append(inclusionProofs, proof.Proof)
+			}
+		}
+	}
+
+	// All locked records are updated in one statement; records without a
+	// proof keep their attestation columns.
+	var written []int
+	updated := tx.Raw(`
+		UPDATE records AS r
+		SET signed_at = ?,
+			status = ?,
+			canonicalization = ?,
+			attestation_id = CASE WHEN p.id IS NULL THEN r.attestation_id ELSE ? END,
+			leaf_index = CASE WHEN p.id IS NULL THEN r.leaf_index ELSE p.leaf_index END,
+			inclusion_proof = CASE WHEN p.id IS NULL THEN r.inclusion_proof ELSE p.inclusion_proof END
+		FROM unnest(?::bigint[]) AS locked(id)
+		LEFT JOIN unnest(?::bigint[], ?::bigint[], ?::bytea[]) AS p(id, leaf_index, inclusion_proof) ON p.id = locked.id
+		WHERE r.id = locked.id
+			AND r.status = ? AND r.batch_id = ?
+			AND (SELECT count(*) FROM record_signatures WHERE record_id = r.id) >= r.signatures_required
+		RETURNING r.id`,
+		now, models.RecordStatusSigned, canonicalization, attestationID,
+		intArray(owned), proofIDs, leafIndexes, inclusionProofs,
+		models.RecordStatusQueued, batchID).
+		Scan(&written)
+
+	if updated.Error != nil {
+		return nil, fmt.Errorf("failed to update record signatures: %w", updated.Error)
+	}
+
+	sort.Ints(written)
+	result.Written = written
+
+	if len(written) < len(ids) {
+		if err := classifyUnwritten(tx, ids, written, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if inserted != nil {
+		attestation.ID = inserted.ID
+		attestation.CreatedAt = inserted.CreatedAt
+	}
+
+	return result, nil
+}
+
+// classifyUnwritten sorts the records of ids that are not in written, both
+// sorted, into result by their current status.
+func classifyUnwritten(tx *gorm.DB, ids, written []int, result *SignatureResult) error {
+	var unwritten intArray
+	for i, j := 0, 0; i < len(ids); i++ {
+		if j < len(written) && written[j] == ids[i] {
+			j++
+			continue
+		}
+		unwritten = append(unwritten, ids[i])
+	}
+
+	var records []models.Record
+	if err := tx.Select("id", "status").Where("id = ANY(?::bigint[])", unwritten).Find(&records).Error; err != nil {
+		return fmt.Errorf("failed to query unwritten records: %w", err)
+	}
+
+	statuses := make(map[int]models.RecordStatus, len(records))
+	for _, record := range records {
+		statuses[record.ID] = record.Status
+	}
+
+	for _, id := range unwritten {
+		if statuses[id] == models.RecordStatusSigned {
+			result.AlreadySigned = append(result.AlreadySigned, id)
+			continue
+		}
+		if result.WrongState == nil {
+			result.WrongState = make(map[int]models.RecordStatus)
+		}
+		result.WrongState[id] = statuses[id]
+	}
+
+	return nil
+}
+
+type RecordFilter struct {
+	FromID       int
+	ToID         int
+	KeyID        int
+	SignedAfter  *time.Time
+	SignedBefore *time.Time
+}
+
+// GetRecordsPage returns up to limit records matching filter with an ID
+// greater than afterID, ordered by ID, so callers can page through the table.
+func (db *DB) GetRecordsPage(ctx context.Context, filter RecordFilter, afterID int, limit int) ([]*models.Record, error) {
+	var records []*models.Record
+
+	query := db.gorm.WithContext(ctx).Where("id > ?", afterID)
+	if filter.FromID > 0 {
+		query = query.Where("id >= ?", filter.FromID)
+	}
+	if filter.ToID > 0 {
+		query = query.Where("id <= ?", filter.ToID)
+	}
+	if filter.KeyID > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM record_signatures WHERE record_id = records.id AND key_id = ?)", filter.KeyID)
+	}
+	if filter.SignedAfter != nil {
+		query = query.Where("signed_at >= ?", *filter.SignedAfter)
+	}
+	if filter.SignedBefore != nil {
+		query = query.Where("signed_at < ?", *filter.SignedBefore)
+	}
+
+	result := query.
+		Preload("Signatures", func(tx *gorm.DB) *gorm.DB { return tx.Order("key_id") }).
+		Order("id").
+		Limit(limit).
+		Find(&records)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query records: %w", result.Error)
+	}
+
+	return records, nil
+}
+
+func (db *DB) GetSigningKeys(ctx context.Context) ([]*models.SigningKey, error) {
+	var keys []*models.SigningKey
+
+	result := db.gorm.WithContext(ctx).
+		Omit("private_key").
+		Order("id").
+		Find(&keys)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", result.Error)
+	}
+
+	return keys, nil
+}
+
+// RewrapSigningKeys locks every signing key and calls rewrap on it inside a
+// single transaction. Keys for which rewrap reports a change have their
+// ciphertext and KEK version written back; any error rolls back all keys.
+func (db *DB) RewrapSigningKeys(ctx context.Context, rewrap func(key *models.SigningKey) (bool, error)) (int, error) {
+	rewrapped := 0
+
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var keys []*models.SigningKey
+
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Order("id").
+			Find(&keys)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, key := range keys {
+			changed, err := rewrap(key)
+			if err != nil {
+				return fmt.Errorf("key %d: %w", key.ID, err)
+			}
+
+			if !changed {
+				continue
+			}
+
+			result := tx.Model(key).
+				Updates(map[string]interface{}{
+					"private_key": key.PrivateKey,
+					"kek_version": key.KEKVersion,
+					"wrap_format": key.WrapFormat,
+				})
+
+			if result.Error != nil {
+				return fmt.Errorf("key %d: %w", key.ID, result.Error)
+			}
+
+			rewrapped++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrap signing keys: %w", err)
+	}
+
+	return rewrapped, nil
+}
+
+// GetSigningKey returns a single key including its wrapped private key.
+func (db *DB) GetSigningKey(ctx context.Context, keyID int) (*models.SigningKey, error) {
+	var key models.SigningKey
+
+	result := db.gorm.WithContext(ctx).
+		Where("id = ?", keyID).
+		Limit(1).
+		Find(&key)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query signing key %d: %w", keyID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &key, nil
+}
+
+// GetWrappedSigningKeys returns every key including its wrapped private key.
+func (db *DB) GetWrappedSigningKeys(ctx context.Context) ([]*models.SigningKey, error) {
+	var keys []*models.SigningKey
+
+	result := db.gorm.WithContext(ctx).
+		Order("id").
+		Find(&keys)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", result.Error)
+	}
+
+	return keys, nil
+}
+
+func (db *DB) GetBatchAttestation(ctx context.Context, id int) (*models.BatchAttestation, error) {
+	var attestation models.BatchAttestation
+
+	result := db.gorm.WithContext(ctx).
+		Where("id = ?", id).
+		Limit(1).
+		Find(&attestation)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query batch attestation %d: %w", id, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &attestation, nil
+}
+
+// SetKeyState moves a key to RETIRED or REVOKED. Only active keys can be
+// retired; revocation is allowed from any state except REVOKED, which is
+// final.
+func (db *DB) SetKeyState(ctx context.Context, keyID int, state models.KeyState, actor string, reason string) (*models.SigningKey, error) {
 	var key models.SigningKey
 
 	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		result := tx.
-			Where("in_use = ?", false).
-			Order("last_used NULLS FIRST, id").
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Omit("private_key").
+			Where("id = ?", keyID).
 			Limit(1).
 			Find(&key)
 
@@ -137,85 +1171,212 @@ func (db *DB) GetLeastRecentlyUsedKey(ctx context.Context) (*models.SigningKey,
 		}
 
 		if result.RowsAffected == 0 {
-			return fmt.Errorf("no available signing keys found")
+			return fmt.Errorf("signing key %d not found", keyID)
 		}
 
 		now := time.Now()
-		result = tx.Model(&key).
+		updates := map[string]interface{}{"state": state}
+
+		switch {
+		case state == models.KeyStateRetired && key.State == models.KeyStateActive:
+			updates["retired_at"] = now
+			key.RetiredAt = &now
+		case state == models.KeyStateRevoked && key.State != models.KeyStateRevoked:
+			updates["revoked_at"] = now
+			key.RevokedAt = &now
+		default:
+			return fmt.Errorf("%w: %s to %s", ErrInvalidKeyTransition, key.State, state)
+		}
+
+		if result := tx.Model(&key).Updates(updates); result.Error != nil {
+			return result.Error
+		}
+
+		key.State = state
+		return insertKeyEvent(tx, key.ID, models.KeyEventType(state), actor, reason)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to set key %d to %s: %w", keyID, state, err)
+	}
+
+	return &key, nil
+}
+
+// SetKeyValidity sets the window in which a key may be selected for signing.
+// A nil bound leaves that side of the window open.
+func (db *DB) SetKeyValidity(ctx context.Context, keyID int, notBefore, notAfter *time.Time, actor string, reason string) error {
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.SigningKey{}).
+			Where("id = ?", keyID).
 			Updates(map[string]interface{}{
-				"in_use":    true,
-				"last_used": now,
+				"not_before": notBefore,
+				"not_after":  notAfter,
 			})
 
 		if result.Error != nil {
 			return result.Error
 		}
 
-		key.LastUsed = &now
-		key.InUse = true
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("signing key %d not found", keyID)
+		}
+
+		return insertKeyEvent(tx, keyID, models.KeyEventValidity, actor, reason)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set validity of key %d: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// KeyRotation is the outcome of a rotation plan: the keys to retire with the
+// reason for each, and the new keys to insert in their place.
+type KeyRotation struct {
+	Retire map[int]string
+	Create []*models.SigningKey
+	Wrap   func(key *models.SigningKey) error
+}
+
+// rotationLockID serializes rotations across processes via
+// pg_advisory_xact_lock.
+const rotationLockID = 0x6b6579726f74
+
+// RotateSigningKeys runs plan against the active keys, then retires and
+// creates keys as planned, writing an audit event for each. Everything
+// happens in one transaction holding an advisory lock, so concurrent
+// rotators cannot both top up the pool.
+func (db *DB) RotateSigningKeys(ctx context.Context, actor string, plan func(active []*models.SigningKey) (*KeyRotation, error)) (*KeyRotation, error) {
+	var rotation *KeyRotation
+
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rotationLockID).Error; err != nil {
+			return err
+		}
+
+		var active []*models.SigningKey
+		result := tx.Omit("private_key").
+			Where("state = ?", models.KeyStateActive).
+			Order("created_at, id").
+			Find(&active)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var err error
+		rotation, err = plan(active)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for keyID, reason := range rotation.Retire {
+			result := tx.Model(&models.SigningKey{}).
+				Where("id = ? AND state = ?", keyID, models.KeyStateActive).
+				Updates(map[string]interface{}{
+					"state":      models.KeyStateRetired,
+					"retired_at": now,
+				})
+
+			if result.Error != nil {
+				return fmt.Errorf("key %d: %w", keyID, result.Error)
+			}
+
+			if err := insertKeyEvent(tx, keyID, models.KeyEventRetired, actor, reason); err != nil {
+				return err
+			}
+		}
+
+		if len(rotation.Create) > 0 {
+			return insertSigningKeys(tx, rotation.Create, rotation.Wrap, actor, "rotation")
+		}
 
 		return nil
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get least recently used key: %w", err)
+		return nil, fmt.Errorf("failed to rotate signing keys: %w", err)
 	}
 
-	return &key, nil
+	return rotation, nil
 }
 
-func (db *DB) ReleaseKey(ctx context.Context, keyID int) error {
-	result := db.gorm.WithContext(ctx).
-		Model(&models.SigningKey{}).
-		Where("id = ?", keyID).
-		Update("in_use", false)
+// GetKeyAuditEvents returns up to limit audit events, newest first,
+// optionally for a single key.
+func (db *DB) GetKeyAuditEvents(ctx context.Context, keyID int, limit int) ([]*models.KeyAuditEvent, error) {
+	var events []*models.KeyAuditEvent
 
+	query := db.gorm.WithContext(ctx)
+	if keyID > 0 {
+		query = query.Where("key_id = ?", keyID)
+	}
+
+	result := query.Order("created_at DESC, id DESC").Limit(limit).Find(&events)
 	if result.Error != nil {
-		return fmt.Errorf("failed to release key %d: %w", keyID, result.Error)
+		return nil, fmt.Errorf("failed to query key audit events: %w", result.Error)
 	}
 
-	return nil
+	return events, nil
 }
 
-func (db *DB) UpdateRecordSignatures(ctx context.Context, signatures map[int][]byte, keyID int) error {
-	if len(signatures) == 0 {
-		return nil
-	}
+// SetKeyQuota sets the maximum number of signatures a key may produce; zero
+// removes the limit.
+func (db *DB) SetKeyQuota(ctx context.Context, keyID int, maxSignatures int64, actor string, reason string) error {
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.SigningKey{}).
+			Where("id = ?", keyID).
+			Update("max_signatures", maxSignatures)
 
-	now := time.Now()
+		if result.Error != nil {
+			return result.Error
+		}
 
-	ids := make([]int, 0, len(signatures))
-	for id := range signatures {
-		ids = append(ids, id)
-	}
-	sort.Ints(ids)
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("signing key %d not found", keyID)
+		}
 
-	tx := db.gorm.WithContext(ctx).Begin()
+		if reason == "" {
+			reason = fmt.Sprintf("max signatures set to %d", maxSignatures)
+		}
 
-	if tx.Error != nil {
-		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+		return insertKeyEvent(tx, keyID, models.KeyEventQuota, actor, reason)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set quota of key %d: %w", keyID, err)
 	}
 
-	defer tx.Rollback()
+	return nil
+}
 
-	// Process records in sorted order to prevent deadlocks
-	for _, id := range ids {
-		result := tx.Model(&models.Record{}).
-			Where("id = ? AND status = ?", id, models.RecordStatusQueued).
-			Updates(map[string]interface{}{
-				"signature": signatures[id],
-				"signed_by": keyID,
-				"signed_at": now,
-				"status":    models.RecordStatusSigned,
-			})
+// SetKeyLabel sets the label that weighted key selection uses to weigh the
+// key.
+func (db *DB) SetKeyLabel(ctx context.Context, keyID int, label string, actor string, reason string) error {
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.SigningKey{}).
+			Where("id = ?", keyID).
+			Update("label", label)
 
 		if result.Error != nil {
-			return fmt.Errorf("failed to update signature for record %d: %w", id, result.Error)
+			return result.Error
 		}
-	}
 
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("signing key %d not found", keyID)
+		}
+
+		if reason == "" {
+			reason = fmt.Sprintf("label set to %q", label)
+		}
+
+		return insertKeyEvent(tx, keyID, models.KeyEventLabel, actor, reason)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set label of key %d: %w", keyID, err)
 	}
 
 	return nil
@@ -0,0 +1,726 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/config"
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newTestDB connects to TEST_DATABASE_URL and migrates a fresh schema that is
+// dropped when the test ends. Tests are skipped when the variable is unset.
+func newTestDB(tb testing.TB) *DB {
+	tb.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(url), &gorm.Config{})
+	if err != nil {
+		tb.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		tb.Fatalf("Failed to create schema: %v", err)
+	}
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+
+	database, err := New(&config.Config{DatabaseURL: url + separator + "search_path=" + schema})
+	if err != nil {
+		tb.Fatalf("Failed to connect to test schema: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		database.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := database.CreateTables(); err != nil {
+		tb.Fatalf("Failed to create tables: %v", err)
+	}
+
+	return database
+}
+
+func insertTestKeys(tb testing.TB, database *DB, count int) {
+	tb.Helper()
+
+	keys := make([]*models.SigningKey, count)
+	for i := range keys {
+		keys[i] = &models.SigningKey{
+			PublicKey:  []byte{byte(i)},
+			PrivateKey: []byte{byte(i)},
+			State:      models.KeyStateActive,
+		}
+	}
+
+	if err := database.gorm.Create(keys).Error; err != nil {
+		tb.Fatalf("Failed to insert keys: %v", err)
+	}
+}
+
+func TestGetLeastRecentlyUsedKeyIsExclusive(t *testing.T) {
+	database := newTestDB(t)
+
+	const (
+		keyCount   = 5
+		workers    = 32
+		iterations = 50
+	)
+	insertTestKeys(t, database, keyCount)
+
+	ctx := context.Background()
+	var holders sync.Map
+	var acquired, contended atomic.Int64
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		owner := fmt.Sprintf("worker-%d", w)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				key, err := database.GetLeastRecentlyUsedKey(ctx, owner, time.Minute, nil)
+				if errors.Is(err, ErrNoAvailableKey) {
+					contended.Add(1)
+					continue
+				}
+				if err != nil {
+					t.Errorf("Failed to acquire key: %v", err)
+					return
+				}
+
+				if _, loaded := holders.LoadOrStore(key.ID, true); loaded {
+					t.Errorf("Key %d acquired while already held", key.ID)
+				}
+				acquired.Add(1)
+
+				time.Sleep(time.Millisecond)
+
+				holders.Delete(key.ID)
+				if err := database.ReleaseKey(ctx, key.ID, owner); err != nil {
+					t.Errorf("Failed to release key %d: %v", key.ID, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquired.Load() == 0 {
+		t.Fatalf("Expected at least one successful acquisition")
+	}
+	t.Logf("%d acquisitions, %d attempts found no free key", acquired.Load(), contended.Load())
+
+	var inUse int64
+	database.gorm.Model(&models.SigningKey{}).Where("in_use = ?", true).Count(&inUse)
+	if inUse != 0 {
+		t.Errorf("Expected all keys released, %d still in use", inUse)
+	}
+}
+
+func TestGetLeastRecentlyUsedKeyRespectsExclusions(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 2)
+
+	ctx := context.Background()
+
+	first, err := database.GetLeastRecentlyUsedKey(ctx, "worker", time.Minute, nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire key: %v", err)
+	}
+
+	if _, err := database.GetLeastRecentlyUsedKey(ctx, "worker", time.Minute, []int{3 - first.ID}); !errors.Is(err, ErrNoAvailableKey) {
+		t.Errorf("Expected ErrNoAvailableKey when the only free key is excluded, got: %v", err)
+	}
+}
+
+func TestExpiredLeaseIsReclaimed(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+
+	ctx := context.Background()
+
+	crashed, err := database.GetLeastRecentlyUsedKey(ctx, "crashed", 50*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire key: %v", err)
+	}
+
+	if _, err := database.GetLeastRecentlyUsedKey(ctx, "other", time.Minute, nil); !errors.Is(err, ErrNoAvailableKey) {
+		t.Fatalf("Expected ErrNoAvailableKey while the lease is live, got: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := database.RenewKeyLease(ctx, crashed.ID, "crashed", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost renewing an expired lease, got: %v", err)
+	}
+
+	reclaimed, err := database.GetLeastRecentlyUsedKey(ctx, "other", time.Minute, nil)
+	if err != nil {
+		t.Fatalf("Expected expired lease to be reclaimed: %v", err)
+	}
+	if reclaimed.ID != crashed.ID || reclaimed.LeasedBy != "other" {
+		t.Errorf("Expected key %d leased by other, got key %d leased by %q", crashed.ID, reclaimed.ID, reclaimed.LeasedBy)
+	}
+
+	if err := database.ReleaseKey(ctx, crashed.ID, "crashed"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost releasing a reclaimed key, got: %v", err)
+	}
+
+	if err := database.RenewKeyLease(ctx, reclaimed.ID, "other", time.Minute); err != nil {
+		t.Errorf("Expected new holder to renew its lease, got: %v", err)
+	}
+	if err := database.ReleaseKey(ctx, reclaimed.ID, "other"); err != nil {
+		t.Errorf("Expected new holder to release its lease, got: %v", err)
+	}
+
+	events, err := database.GetKeyAuditEvents(ctx, crashed.ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to get audit events: %v", err)
+	}
+	if len(events) == 0 || events[0].Event != models.KeyEventReclaimed {
+		t.Errorf("Expected a RECLAIMED audit event, got: %+v", events)
+	}
+}
+
+func TestRetireExhaustedKeyRecordsExpiredLease(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+
+	ctx := context.Background()
+	expired := time.Now().Add(-time.Minute)
+	database.gorm.Model(&models.SigningKey{}).Where("id = ?", 1).Updates(map[string]interface{}{
+		"in_use":          true,
+		"leased_by":       "crashed",
+		"leased_until":    expired,
+		"max_signatures":  10,
+		"signature_count": 10,
+	})
+
+	if _, err := database.GetLeastRecentlyUsedKey(ctx, "other", time.Minute, nil); !errors.Is(err, ErrNoAvailableKey) {
+		t.Fatalf("Expected ErrNoAvailableKey with the only key exhausted, got: %v", err)
+	}
+
+	events, err := database.GetKeyAuditEvents(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("Failed to get audit events: %v", err)
+	}
+
+	seen := make(map[models.KeyEventType]bool)
+	for _, event := range events {
+		seen[event.Event] = true
+	}
+	if !seen[models.KeyEventReclaimed] || !seen[models.KeyEventRetired] {
+		t.Errorf("Expected RECLAIMED and RETIRED audit events, got: %+v", events)
+	}
+}
+
+func TestClaimPendingRecordsIsExclusive(t *testing.T) {
+	database := newTestDB(t)
+
+	const (
+		recordCount = 2000
+		dispatchers = 8
+		batchSize   = 25
+	)
+
+	records := make([]*models.Record, recordCount)
+	for i := range records {
+		records[i] = &models.Record{Payload: []byte(fmt.Sprintf(`{"n":%d}`, i))}
+	}
+	if err := database.InsertRecords(records); err != nil {
+		t.Fatalf("Failed to insert records: %v", err)
+	}
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	claimedBy := make(map[int]int, recordCount)
+
+	var wg sync.WaitGroup
+	for d := 0; d < dispatchers; d++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				batch, err := database.ClaimPendingRecords(ctx, batchSize, testOutboxMessage)
+				if err != nil {
+					t.Errorf("Failed to claim records: %v", err)
+					return
+				}
+				if len(batch) == 0 {
+					return
+				}
+
+				mu.Lock()
+				for _, record := range batch {
+					if record.Status != models.RecordStatusQueued {
+						t.Errorf("Record %d claimed with status %s", record.ID, record.Status)
+					}
+					if other, ok := claimedBy[record.ID]; ok {
+						t.Errorf("Record %d claimed by dispatchers %d and %d", record.ID, other, d)
+					}
+					claimedBy[record.ID] = d
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimedBy) != recordCount {
+		t.Errorf("Expected %d records claimed, got %d", recordCount, len(claimedBy))
+	}
+
+	var pending int64
+	database.gorm.Model(&models.Record{}).Where("status = ?", models.RecordStatusPending).Count(&pending)
+	if pending != 0 {
+		t.Errorf("Expected no pending records left, got %d", pending)
+	}
+
+	var messages []*models.OutboxMessage
+	database.gorm.Find(&messages)
+
+	inOutbox := make(map[int]int, recordCount)
+	for _, msg := range messages {
+		var ids []int
+		if err := json.Unmarshal(msg.Payload, &ids); err != nil {
+			t.Fatalf("Failed to decode outbox message: %v", err)
+		}
+		for _, id := range ids {
+			inOutbox[id]++
+		}
+	}
+	for id := range claimedBy {
+		if inOutbox[id] != 1 {
+			t.Errorf("Expected record %d in exactly one outbox message, found %d", id, inOutbox[id])
+		}
+	}
+}
+
+// testOutboxMessage stands in for the dispatcher's batch message and lists
+// the record IDs.
+func testOutboxMessage(batchID string, records []*models.Record) (*models.OutboxMessage, error) {
+	ids := make([]int, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+
+	payload, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxMessage{MessageID: batchID, Subject: "test", Payload: payload}, nil
+}
+
+func TestRequeueStaleRecords(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 4)
+
+	ctx := context.Background()
+
+	claimed, err := database.ClaimPendingRecords(ctx, 2, testOutboxMessage)
+	if err != nil {
+		t.Fatalf("Failed to claim records: %v", err)
+	}
+	for _, record := range claimed {
+		if record.QueuedAt == nil {
+			t.Errorf("Expected record %d to have queued_at", record.ID)
+		}
+	}
+
+	stale := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	database.gorm.Model(&models.Record{}).Where("id = ?", claimed[0].ID).Update("queued_at", stale)
+
+	requeued, err := database.RequeueStaleRecords(ctx, time.Now().Add(-time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to requeue records: %v", err)
+	}
+	if len(requeued) != 1 || requeued[0].ID != claimed[0].ID {
+		t.Fatalf("Expected only record %d to be requeued, got %+v", claimed[0].ID, requeued)
+	}
+	if requeued[0].QueuedAt == nil || !requeued[0].QueuedAt.Equal(stale) {
+		t.Errorf("Expected the original queue time to be returned, got %v", requeued[0].QueuedAt)
+	}
+
+	var record models.Record
+	database.gorm.First(&record, claimed[0].ID)
+	if record.Status != models.RecordStatusPending || record.QueuedAt != nil {
+		t.Errorf("Expected requeued record to be PENDING without queued_at, got %s %v", record.Status, record.QueuedAt)
+	}
+
+	database.gorm.First(&record, claimed[1].ID)
+	if record.Status != models.RecordStatusQueued {
+		t.Errorf("Expected fresh record to stay QUEUED, got %s", record.Status)
+	}
+}
+
+func TestUpdateRecordSignaturesRejectsLateBatch(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+	insertTestRecords(t, database, 1)
+
+	ctx := context.Background()
+
+	late, err := database.ClaimPendingRecords(ctx, 1, testOutboxMessage)
+	if err != nil || len(late) != 1 {
+		t.Fatalf("Failed to claim record: %v", err)
+	}
+	if _, err := database.RequeueStaleRecords(ctx, time.Now().Add(time.Minute), 10); err != nil {
+		t.Fatalf("Failed to requeue record: %v", err)
+	}
+	current, err := database.ClaimPendingRecords(ctx, 1, testOutboxMessage)
+	if err != nil || len(current) != 1 {
+		t.Fatalf("Failed to claim record again: %v", err)
+	}
+
+	ids := []int{late[0].ID}
+	signatures := []*models.RecordSignature{{RecordID: late[0].ID, KeyID: 1, Signature: []byte{1}}}
+
+	result, err := database.UpdateRecordSignatures(ctx, late[0].BatchID, ids, signatures, "none", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to store signatures: %v", err)
+	}
+	if len(result.Written) != 0 || result.WrongState[ids[0]] != models.RecordStatusQueued {
+		t.Errorf("Expected the late batch to be rejected, got %+v", result)
+	}
+
+	signatures = []*models.RecordSignature{{RecordID: late[0].ID, KeyID: 1, Signature: []byte{1}}}
+	result, err = database.UpdateRecordSignatures(ctx, current[0].BatchID, ids, signatures, "none", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to store signatures: %v", err)
+	}
+	if len(result.Written) != 1 || result.Conflicts() != 0 {
+		t.Errorf("Expected the current batch to write the record, got %+v", result)
+	}
+}
+
+func TestUpdateRecordSignaturesReportsConflicts(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+	insertTestRecords(t, database, 3)
+
+	ctx := context.Background()
+
+	claimed, err := database.ClaimPendingRecords(ctx, 3, testOutboxMessage)
+	if err != nil || len(claimed) != 3 {
+		t.Fatalf("Failed to claim records: %v", err)
+	}
+	ids := []int{claimed[0].ID, claimed[1].ID, claimed[2].ID}
+
+	signatures := func(ids ...int) []*models.RecordSignature {
+		var signatures []*models.RecordSignature
+		for _, id := range ids {
+			signatures = append(signatures, &models.RecordSignature{RecordID: id, KeyID: 1, Signature: []byte{1}})
+		}
+		return signatures
+	}
+
+	batchID := claimed[0].BatchID
+	if _, err := database.UpdateRecordSignatures(ctx, batchID, ids[:1], signatures(ids[0]), "none", nil, nil); err != nil {
+		t.Fatalf("Failed to store signatures: %v", err)
+	}
+	if _, _, err := database.RecordBatchFailure(ctx, batchID, ids[1:2], "boom", 1); err != nil {
+		t.Fatalf("Failed to park record: %v", err)
+	}
+
+	// The batch is delivered again after its first record was signed and
+	// its second parked.
+	result, err := database.UpdateRecordSignatures(ctx, batchID, ids, signatures(ids...), "none", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to store signatures: %v", err)
+	}
+
+	if len(result.Written) != 1 || result.Written[0] != ids[2] {
+		t.Errorf("Expected only record %d written, got %v", ids[2], result.Written)
+	}
+	if len(result.AlreadySigned) != 1 || result.AlreadySigned[0] != ids[0] {
+		t.Errorf("Expected record %d already signed, got %v", ids[0], result.AlreadySigned)
+	}
+	if len(result.WrongState) != 1 || result.WrongState[ids[1]] != models.RecordStatusFailed {
+		t.Errorf("Expected record %d in state FAILED, got %v", ids[1], result.WrongState)
+	}
+	if result.Conflicts() != 2 {
+		t.Errorf("Expected 2 conflicts, got %d", result.Conflicts())
+	}
+
+	// The parked record must not gain a signature, and the key is only
+	// charged for the first delivery of ids[0] and the new one for ids[2].
+	var parkedSignatures int64
+	database.gorm.Model(&models.RecordSignature{}).Where("record_id = ?", ids[1]).Count(&parkedSignatures)
+	if parkedSignatures != 0 {
+		t.Errorf("Expected no signatures on parked record %d, got %d", ids[1], parkedSignatures)
+	}
+
+	var key models.SigningKey
+	if err := database.gorm.First(&key, 1).Error; err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+	if key.SignatureCount != 2 {
+		t.Errorf("Expected key signature count 2, got %d", key.SignatureCount)
+	}
+}
+
+func TestRecordBatchFailureParksAndRetries(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 2)
+
+	ctx := context.Background()
+
+	claimed, err := database.ClaimPendingRecords(ctx, 2, testOutboxMessage)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("Failed to claim records: %v", err)
+	}
+	ids := []int{claimed[0].ID, claimed[1].ID}
+
+	// The first record failed once before, in another batch.
+	database.gorm.Model(&models.Record{}).Where("id = ?", ids[0]).Update("attempts", 1)
+
+	parked, waiting, err := database.RecordBatchFailure(ctx, claimed[0].BatchID, ids, "boom", 2)
+	if err != nil {
+		t.Fatalf("Failed to record batch failure: %v", err)
+	}
+	if parked != 1 || waiting != 1 {
+		t.Fatalf("Expected 1 parked and 1 waiting record, got %d and %d", parked, waiting)
+	}
+
+	failed, err := database.GetFailedRecords(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Failed to get failed records: %v", err)
+	}
+	if len(failed) != 1 || failed[0].ID != ids[0] || failed[0].Attempts != 2 || failed[0].LastError != "boom" {
+		t.Fatalf("Expected record %d parked after 2 attempts, got %+v", ids[0], failed)
+	}
+
+	if parked, waiting, _ := database.RecordBatchFailure(ctx, claimed[0].BatchID, ids, "boom", 2); parked != 1 || waiting != 0 {
+		t.Errorf("Expected the second record parked and nothing waiting, got %d and %d", parked, waiting)
+	}
+
+	retried, err := database.RetryFailedRecords(ctx, []int{ids[0]})
+	if err != nil || retried != 1 {
+		t.Fatalf("Expected 1 record retried, got %d: %v", retried, err)
+	}
+
+	var record models.Record
+	database.gorm.First(&record, ids[0])
+	if record.Status != models.RecordStatusPending || record.Attempts != 0 || record.LastError != "" {
+		t.Errorf("Expected retried record to be PENDING with no attempts, got %+v", record)
+	}
+
+	if retried, _ := database.RetryFailedRecords(ctx, nil); retried != 1 {
+		t.Errorf("Expected the remaining failed record to be retried, got %d", retried)
+	}
+}
+
+func TestUpdateRecordSignaturesLinksSplitBatchToAttestation(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+	insertTestRecords(t, database, 2)
+
+	ctx := context.Background()
+
+	claimed, err := database.ClaimPendingRecords(ctx, 2, testOutboxMessage)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("Failed to claim records: %v", err)
+	}
+	ids := []int{claimed[0].ID, claimed[1].ID}
+
+	attestation := &models.BatchAttestation{BatchID: uuid.NewString(), KeyID: 1, RootHash: []byte{1}, TreeSize: 2, Signature: []byte{1}}
+	proofs := map[int]RecordProof{ids[0]: {LeafIndex: 0}, ids[1]: {LeafIndex: 1}}
+
+	// Key 999 does not exist, so the whole write fails.
+	signatures := []*models.RecordSignature{
+		{RecordID: ids[0], KeyID: 1, Signature: []byte{1}},
+		{RecordID: ids[1], KeyID: 999, Signature: []byte{1}},
+	}
+	if _, err := database.UpdateRecordSignatures(ctx, claimed[0].BatchID, ids, signatures, "none", attestation, proofs); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if attestation.ID != 0 {
+		t.Fatalf("Expected the attestation to stay unstored, got ID %d", attestation.ID)
+	}
+
+	for i, id := range ids {
+		signatures := []*models.RecordSignature{{RecordID: id, KeyID: 1, Signature: []byte{1}}}
+		if _, err := database.UpdateRecordSignatures(ctx, claimed[0].BatchID, []int{id}, signatures, "none", attestation, proofs); err != nil {
+			t.Fatalf("Failed to store signatures of record %d: %v", id, err)
+		}
+
+		var record models.Record
+		database.gorm.First(&record, id)
+		if record.Status != models.RecordStatusSigned || record.AttestationID == nil || *record.AttestationID != attestation.ID {
+			t.Errorf("Expected record %d signed and linked to attestation %d, got %s %v", id, attestation.ID, record.Status, record.AttestationID)
+		}
+		if record.LeafIndex == nil || *record.LeafIndex != i {
+			t.Errorf("Expected record %d at leaf %d, got %v", id, i, record.LeafIndex)
+		}
+	}
+}
+
+// BenchmarkUpdateRecordSignatures compares the set-based write with one
+// UPDATE per record, as it was done before, at several batch sizes:
+//
+//	TEST_DATABASE_URL=... go test ./internal/db -run '^$' -bench UpdateRecordSignatures
+func BenchmarkUpdateRecordSignatures(b *testing.B) {
+	database := newTestDB(b)
+	insertTestKeys(b, database, 1)
+
+	writes := []struct {
+		name  string
+		write func(ctx context.Context, batchID string, ids []int, signatures []*models.RecordSignature) error
+	}{
+		{"set", func(ctx context.Context, batchID string, ids []int, signatures []*models.RecordSignature) error {
+			_, err := database.UpdateRecordSignatures(ctx, batchID, ids, signatures, "none", nil, nil)
+			return err
+		}},
+		{"per-record", func(ctx context.Context, batchID string, ids []int, signatures []*models.RecordSignature) error {
+			return updateRecordSignaturesPerRecord(ctx, database, batchID, ids, signatures)
+		}},
+	}
+
+	for _, size := range []int{100, 1000, 10000} {
+		for _, w := range writes {
+			b.Run(fmt.Sprintf("%s/%d", w.name, size), func(b *testing.B) {
+				ctx := context.Background()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					batchID, ids, signatures := queueTestRecords(b, database, size)
+					b.StartTimer()
+
+					if err := w.write(ctx, batchID, ids, signatures); err != nil {
+						b.Fatalf("Failed to store signatures: %v", err)
+					}
+				}
+				b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "records/s")
+			})
+		}
+	}
+}
+
+// queueTestRecords inserts count records QUEUED as one batch and returns
+// the batch ID and the record IDs with one signature each from key 1.
+func queueTestRecords(tb testing.TB, database *DB, count int) (string, []int, []*models.RecordSignature) {
+	tb.Helper()
+
+	batchID := uuid.NewString()
+	records := make([]*models.Record, count)
+	for i := range records {
+		records[i] = &models.Record{Payload: []byte(`{}`), Status: models.RecordStatusQueued, BatchID: batchID}
+	}
+	if err := database.InsertRecords(records); err != nil {
+		tb.Fatalf("Failed to insert records: %v", err)
+	}
+
+	ids := make([]int, count)
+	signatures := make([]*models.RecordSignature, count)
+	for i, record := range records {
+		ids[i] = record.ID
+		signatures[i] = &models.RecordSignature{RecordID: record.ID, KeyID: 1, Signature: make([]byte, 64)}
+	}
+	return batchID, ids, signatures
+}
+
+// updateRecordSignaturesPerRecord updates records one UPDATE at a time, as
+// UpdateRecordSignatures did before, as the baseline for its benchmark.
+func updateRecordSignaturesPerRecord(ctx context.Context, database *DB, batchID string, ids []int, signatures []*models.RecordSignature) error {
+	now := time.Now()
+
+	return database.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := insertKeySignatures(tx, 1, batchID, signatures, now); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			result := tx.Model(&models.Record{}).
+				Where("id = ? AND status = ? AND batch_id = ?", id, models.RecordStatusQueued, batchID).
+				Where("(SELECT count(*) FROM record_signatures WHERE record_id = records.id) >= signatures_required").
+				Updates(map[string]interface{}{
+					"signed_at":        now,
+					"status":           models.RecordStatusSigned,
+					"canonicalization": "none",
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+func TestUpdateRecordSignaturesRejectsRevokedKey(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+	insertTestRecords(t, database, 1)
+
+	ctx := context.Background()
+
+	claimed, err := database.ClaimPendingRecords(ctx, 1, testOutboxMessage)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Failed to claim record: %v", err)
+	}
+
+	// The key is revoked while a worker is signing with it.
+	if _, err := database.SetKeyState(ctx, 1, models.KeyStateRevoked, "test", "compromised"); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+
+	ids := []int{claimed[0].ID}
+	signatures := []*models.RecordSignature{{RecordID: claimed[0].ID, KeyID: 1, Signature: []byte{1}}}
+	if _, err := database.UpdateRecordSignatures(ctx, claimed[0].BatchID, ids, signatures, "none", nil, nil); !errors.Is(err, ErrKeyRevoked) {
+		t.Fatalf("Expected ErrKeyRevoked, got: %v", err)
+	}
+
+	var count int64
+	database.gorm.Model(&models.RecordSignature{}).Where("record_id = ?", claimed[0].ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no signatures stored from the revoked key, got %d", count)
+	}
+}
+
+func TestIsDataError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unique violation", &pgconn.PgError{Code: "23505"}, true},
+		{"invalid text", fmt.Errorf("failed to update record signatures: %w", &pgconn.PgError{Code: "22P02"}), true},
+		{"statement timeout", &pgconn.PgError{Code: "57014"}, false},
+		{"quota", fmt.Errorf("%w: key 1 cannot take 2 more signatures", ErrQuotaExceeded), false},
+		{"context", context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDataError(tt.err); got != tt.want {
+				t.Errorf("IsDataError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
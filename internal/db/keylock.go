@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Key lock strategies accepted by NewKeyLocker.
+const (
+	// KeyLockFlag marks a key with in_use until it is released. A key held
+	// by a crashed worker stays out of the pool until it is freed by hand.
+	KeyLockFlag = "flag"
+	// KeyLockLease marks a key with in_use under a lease that expires unless
+	// renewed, so keys held by a crashed worker are reclaimed.
+	KeyLockLease = "lease"
+	// KeyLockAdvisory holds a session-level Postgres advisory lock on the key
+	// ID. The lock dies with the holder's connection, so a crashed worker
+	// frees its key as soon as Postgres notices the connection drop.
+	KeyLockAdvisory = "advisory"
+)
+
+// keyLockClass namespaces the advisory locks on signing keys, which are
+// taken as pg_advisory_lock(keyLockClass, key ID).
+const keyLockClass = 0x6b6c6f63
+
+// KeyLocker hands out signing keys for exclusive use.
+type KeyLocker interface {
+	// AcquireKey locks the least recently used key that may sign now,
+	// skipping the keys in exclude. It returns ErrNoAvailableKey if every
+	// usable key is held.
+	AcquireKey(ctx context.Context, exclude []int) (KeyLock, error)
+}
+
+// KeyLock is a signing key held by a KeyLocker.
+type KeyLock interface {
+	Key() *models.SigningKey
+	// Renew confirms the key is still held, extending the lock if it
+	// expires. It returns an error wrapping ErrLeaseLost once the lock is
+	// gone; the holder must then stop signing and discard its signatures.
+	Renew(ctx context.Context) error
+	// Release frees the key. Like Renew, it returns an error wrapping
+	// ErrLeaseLost if the lock was lost before the release. It must be
+	// called even after the lock was lost, to free what the lock still
+	// holds.
+	Release(ctx context.Context) error
+}
+
+// NewKeyLocker returns the key lock strategy named by strategy, taking the
+// first free key in selector's order and holding it in owner's name. ttl is
+// the lease duration for KeyLockLease and is ignored by the other
+// strategies.
+func (db *DB) NewKeyLocker(strategy string, selector KeySelector, owner string, ttl time.Duration) (KeyLocker, error) {
+	switch strategy {
+	case KeyLockFlag:
+		return &rowLocker{db: db, selector: selector, owner: owner}, nil
+	case KeyLockLease:
+		if ttl <= 0 {
+			return nil, fmt.Errorf("lease key lock requires a positive TTL, got %s", ttl)
+		}
+		return &rowLocker{db: db, selector: selector, owner: owner, ttl: ttl}, nil
+	case KeyLockAdvisory:
+		return &advisoryLocker{db: db, selector: selector}, nil
+	default:
+		return nil, fmt.Errorf("unknown key lock strategy %q", strategy)
+	}
+}
+
+// rowLocker holds keys through the in_use, leased_by and leased_until
+// columns. Without a ttl the key is held until released.
+type rowLocker struct {
+	db       *DB
+	selector KeySelector
+	owner    string
+	ttl      time.Duration
+}
+
+func (l *rowLocker) AcquireKey(ctx context.Context, exclude []int) (KeyLock, error) {
+	key, err := l.db.AcquireSigningKey(ctx, l.selector, l.owner, l.ttl, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &rowLock{locker: l, key: key}, nil
+}
+
+type rowLock struct {
+	locker *rowLocker
+	key    *models.SigningKey
+}
+
+func (l *rowLock) Key() *models.SigningKey {
+	return l.key
+}
+
+func (l *rowLock) Renew(ctx context.Context) error {
+	return l.locker.db.RenewKeyLease(ctx, l.key.ID, l.locker.owner, l.locker.ttl)
+}
+
+func (l *rowLock) Release(ctx context.Context) error {
+	return l.locker.db.ReleaseKey(ctx, l.key.ID, l.locker.owner)
+}
+
+// advisoryLocker holds each key through a dedicated connection that keeps a
+// session-level advisory lock on it. The in_use columns are not used, so
+// every worker sharing a key pool must run this strategy.
+type advisoryLocker struct {
+	db       *DB
+	selector KeySelector
+}
+
+func (l *advisoryLocker) AcquireKey(ctx context.Context, exclude []int) (KeyLock, error) {
+	sqlDB, err := l.db.gorm.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key lock connection: %w", err)
+	}
+
+	key, err := l.lockNextKey(ctx, conn, exclude)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &advisoryLock{conn: conn, key: key}, nil
+}
+
+// lockNextKey walks the usable keys in the selector's order and takes the first one
+// whose advisory lock is free. The key is re-read under the lock, since it
+// may have been retired or used up while another worker held it.
+func (l *advisoryLocker) lockNextKey(ctx context.Context, conn *sql.Conn, exclude []int) (*models.SigningKey, error) {
+	gormDB := l.db.gorm.WithContext(ctx)
+
+	if err := gormDB.Transaction(func(tx *gorm.DB) error {
+		return retireExhaustedKeys(tx, time.Now())
+	}); err != nil {
+		return nil, err
+	}
+
+	var candidates []int
+	result := usableKeys(gormDB.Model(&models.SigningKey{}), time.Now(), exclude).
+		Clauses(l.selector.OrderBy()).
+		Pluck("id", &candidates)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", result.Error)
+	}
+
+	for _, keyID := range candidates {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, $2)", keyLockClass, keyID).Scan(&locked); err != nil {
+			return nil, fmt.Errorf("failed to lock key %d: %w", keyID, err)
+		}
+		if !locked {
+			continue
+		}
+
+		now := time.Now()
+
+		var key models.SigningKey
+		result := usableKeys(gormDB, now, nil).Where("id = ?", keyID).Limit(1).Find(&key)
+		if result.Error == nil && result.RowsAffected == 1 {
+			result = gormDB.Model(&key).Update("last_used", now)
+			key.LastUsed = &now
+		}
+
+		if result.Error != nil || result.RowsAffected == 0 {
+			if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, $2)", keyLockClass, keyID); err != nil {
+				return nil, fmt.Errorf("failed to unlock key %d: %w", keyID, err)
+			}
+			if result.Error != nil {
+				return nil, fmt.Errorf("failed to claim key %d: %w", keyID, result.Error)
+			}
+			continue
+		}
+
+		return &key, nil
+	}
+
+	return nil, fmt.Errorf("failed to get least recently used key: %w", ErrNoAvailableKey)
+}
+
+type advisoryLock struct {
+	conn *sql.Conn
+	key  *models.SigningKey
+}
+
+func (l *advisoryLock) Key() *models.SigningKey {
+	return l.key
+}
+
+// Renew checks that the lock's session still holds the advisory lock. Only
+// a check that finds the lock gone reports ErrLeaseLost; a failed query may
+// leave the session and its lock alive, and Release settles which it was.
+func (l *advisoryLock) Renew(ctx context.Context) error {
+	var held bool
+	err := l.conn.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_locks
+			WHERE locktype = 'advisory' AND pid = pg_backend_pid()
+				AND classid = $1::bigint::oid AND objid = $2::bigint::oid AND objsubid = 2 AND granted
+		)`, keyLockClass, l.key.ID).Scan(&held)
+
+	if err != nil {
+		return fmt.Errorf("failed to check advisory lock on key %d: %w", l.key.ID, err)
+	}
+	if !held {
+		return fmt.Errorf("%w: advisory lock on key %d is gone", ErrLeaseLost, l.key.ID)
+	}
+
+	return nil
+}
+
+// Release unlocks the key and returns the connection to the pool. If the
+// unlock fails, the session may still hold the lock, so the connection is
+// discarded instead, which ends the session and frees the lock.
+func (l *advisoryLock) Release(ctx context.Context) error {
+	var unlocked bool
+	err := l.conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1, $2)", keyLockClass, l.key.ID).Scan(&unlocked)
+	if err != nil {
+		l.conn.Raw(func(any) error { return driver.ErrBadConn })
+		l.conn.Close()
+		return fmt.Errorf("%w: failed to unlock key %d: %v", ErrLeaseLost, l.key.ID, err)
+	}
+
+	l.conn.Close()
+	if !unlocked {
+		return fmt.Errorf("%w: advisory lock on key %d is not held", ErrLeaseLost, l.key.ID)
+	}
+
+	return nil
+}
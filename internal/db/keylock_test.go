@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var lru, _ = NewKeySelector(KeySelectLRU, nil)
+
+func TestKeyLockersAreExclusive(t *testing.T) {
+	for _, strategy := range []string{KeyLockFlag, KeyLockLease, KeyLockAdvisory} {
+		t.Run(strategy, func(t *testing.T) {
+			database := newTestDB(t)
+			insertTestKeys(t, database, 3)
+
+			locker, err := database.NewKeyLocker(strategy, lru, "worker", time.Minute)
+			if err != nil {
+				t.Fatalf("Failed to create key locker: %v", err)
+			}
+
+			ctx := context.Background()
+			var holders sync.Map
+			var acquired atomic.Int64
+
+			var wg sync.WaitGroup
+			for w := 0; w < 12; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for i := 0; i < 20; i++ {
+						lock, err := locker.AcquireKey(ctx, nil)
+						if errors.Is(err, ErrNoAvailableKey) {
+							continue
+						}
+						if err != nil {
+							t.Errorf("Failed to acquire key: %v", err)
+							return
+						}
+
+						keyID := lock.Key().ID
+						if _, loaded := holders.LoadOrStore(keyID, true); loaded {
+							t.Errorf("Key %d acquired while already held", keyID)
+						}
+						acquired.Add(1)
+
+						if err := lock.Renew(ctx); err != nil {
+							t.Errorf("Failed to renew lock on key %d: %v", keyID, err)
+						}
+
+						holders.Delete(keyID)
+						if err := lock.Release(ctx); err != nil {
+							t.Errorf("Failed to release key %d: %v", keyID, err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if acquired.Load() == 0 {
+				t.Fatalf("Expected at least one successful acquisition")
+			}
+		})
+	}
+}
+
+func TestAdvisoryLockIsFreedWithConnection(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+
+	locker, err := database.NewKeyLocker(KeyLockAdvisory, lru, "worker", 0)
+	if err != nil {
+		t.Fatalf("Failed to create key locker: %v", err)
+	}
+
+	ctx := context.Background()
+
+	crashed, err := locker.AcquireKey(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire key: %v", err)
+	}
+
+	if _, err := locker.AcquireKey(ctx, nil); !errors.Is(err, ErrNoAvailableKey) {
+		t.Fatalf("Expected ErrNoAvailableKey while the key is locked, got: %v", err)
+	}
+
+	var pid int
+	conn := crashed.(*advisoryLock).conn
+	if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatalf("Failed to get backend pid: %v", err)
+	}
+	if err := database.gorm.Exec("SELECT pg_terminate_backend(?)", pid).Error; err != nil {
+		t.Fatalf("Failed to terminate lock connection: %v", err)
+	}
+
+	if err := crashed.Renew(ctx); err == nil {
+		t.Errorf("Expected Renew to fail after the connection dropped")
+	}
+	if err := crashed.Release(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost releasing after the connection dropped, got: %v", err)
+	}
+
+	var lock KeyLock
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if lock, err = locker.AcquireKey(ctx, nil); !errors.Is(err, ErrNoAvailableKey) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Expected key to be free after the connection dropped: %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Errorf("Failed to release key: %v", err)
+	}
+}
+
+func TestAdvisoryLockLostIsReleased(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 1)
+
+	locker, err := database.NewKeyLocker(KeyLockAdvisory, lru, "worker", 0)
+	if err != nil {
+		t.Fatalf("Failed to create key locker: %v", err)
+	}
+
+	ctx := context.Background()
+
+	lock, err := locker.AcquireKey(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire key: %v", err)
+	}
+
+	conn := lock.(*advisoryLock).conn
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, $2)", keyLockClass, lock.Key().ID); err != nil {
+		t.Fatalf("Failed to unlock key: %v", err)
+	}
+
+	if err := lock.Renew(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost once the lock is gone, got: %v", err)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost releasing a lost lock, got: %v", err)
+	}
+	if err := conn.PingContext(ctx); !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("Expected the lock's connection to be closed, got: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Key selection strategies accepted by NewKeySelector.
+const (
+	KeySelectLRU             = "lru"
+	KeySelectLeastSignatures = "least-signatures"
+	KeySelectWeighted        = "weighted"
+	KeySelectRandom          = "random"
+)
+
+// KeySelectors lists every key selection strategy.
+var KeySelectors = []string{KeySelectLRU, KeySelectLeastSignatures, KeySelectWeighted, KeySelectRandom}
+
+// KeySelector decides which free key is acquired next. The candidate keys
+// are sorted by OrderBy and the first one that is not locked is taken.
+type KeySelector interface {
+	Name() string
+	OrderBy() clause.OrderBy
+}
+
+// NewKeySelector returns the key selection strategy named by name. weights
+// maps key labels to their relative weight for KeySelectWeighted; keys with
+// an unlisted label weigh 1.
+func NewKeySelector(name string, weights map[string]float64) (KeySelector, error) {
+	switch name {
+	case KeySelectLRU:
+		return orderSelector{name: name, sql: "last_used NULLS FIRST, id"}, nil
+	case KeySelectLeastSignatures:
+		return orderSelector{name: name, sql: "signature_count, last_used NULLS FIRST, id"}, nil
+	case KeySelectRandom:
+		return orderSelector{name: name, sql: "random()"}, nil
+	case KeySelectWeighted:
+		return weightedSelector(weights), nil
+	default:
+		return nil, fmt.Errorf("unknown key selection strategy %q", name)
+	}
+}
+
+// ParseKeyWeights parses "label=weight" entries as used by
+// KEY_SELECTION_WEIGHTS. Weights must be positive.
+func ParseKeyWeights(entries []string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(entries))
+	for _, entry := range entries {
+		label, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key weight %q, expected label=weight", entry)
+		}
+
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q for label %q, expected a positive number", value, label)
+		}
+
+		weights[label] = weight
+	}
+	return weights, nil
+}
+
+type orderSelector struct {
+	name string
+	sql  string
+}
+
+func (s orderSelector) Name() string {
+	return s.name
+}
+
+func (s orderSelector) OrderBy() clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{SQL: s.sql}}
+}
+
+// weightedSelector picks a random free key with probability proportional to
+// the weight of its label, by ordering on -ln(u)/weight for a uniform u
+// (Efraimidis-Spirakis sampling).
+type weightedSelector map[string]float64
+
+func (s weightedSelector) Name() string {
+	return KeySelectWeighted
+}
+
+func (s weightedSelector) OrderBy() clause.OrderBy {
+	labels := make([]string, 0, len(s))
+	for label := range s {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	weight := "1"
+	var vars []interface{}
+	if len(labels) > 0 {
+		var sql strings.Builder
+		sql.WriteString("CASE label")
+		for _, label := range labels {
+			sql.WriteString(" WHEN ? THEN ?::float8")
+			vars = append(vars, label, s[label])
+		}
+		sql.WriteString(" ELSE 1 END")
+		weight = sql.String()
+	}
+
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:  "-ln(1 - random()) / (" + weight + ")",
+		Vars: vars,
+	}}
+}
+
+// SimulateKeySelection runs batches acquisitions with selector against a
+// private copy of the key pool, as if each batch signed batchSize records,
+// and returns how many batches each usable key received. The simulation
+// stops early if every key runs out of quota. Nothing is written to the real
+// pool.
+func (db *DB) SimulateKeySelection(ctx context.Context, selector KeySelector, batches int, batchSize int) (map[int]int, error) {
+	counts := make(map[int]int)
+
+	tx := db.gorm.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer tx.Rollback()
+
+	// The temporary table shadows signing_keys for the rest of the
+	// transaction, so the real acquisition query runs against the copy.
+	if err := tx.Exec("CREATE TEMP TABLE signing_keys ON COMMIT DROP AS SELECT * FROM signing_keys").Error; err != nil {
+		return nil, fmt.Errorf("failed to copy key pool: %w", err)
+	}
+	if err := tx.Exec("UPDATE signing_keys SET in_use = false, leased_by = '', leased_until = NULL").Error; err != nil {
+		return nil, fmt.Errorf("failed to reset key pool copy: %w", err)
+	}
+
+	var usable []int
+	if err := usableKeys(tx.Model(&models.SigningKey{}), time.Now(), nil).Pluck("id", &usable).Error; err != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", err)
+	}
+	for _, keyID := range usable {
+		counts[keyID] = 0
+	}
+
+	now := time.Now()
+	for i := 0; i < batches; i++ {
+		// Advance a simulated clock so last_used orders the batches.
+		key, err := acquireKey(tx, selector, "simulation", 0, nil, now.Add(time.Duration(i)*time.Millisecond))
+		if errors.Is(err, ErrNoAvailableKey) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate batch %d: %w", i+1, err)
+		}
+		counts[key.ID]++
+
+		err = tx.Model(key).Updates(map[string]interface{}{
+			"in_use":          false,
+			"leased_by":       "",
+			"signature_count": gorm.Expr("signature_count + ?", batchSize),
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate batch %d: %w", i+1, err)
+		}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func TestParseKeyWeights(t *testing.T) {
+	weights, err := ParseKeyWeights([]string{"hsm=3", "soft=0.5"})
+	if err != nil {
+		t.Fatalf("Failed to parse weights: %v", err)
+	}
+	if weights["hsm"] != 3 || weights["soft"] != 0.5 {
+		t.Errorf("Unexpected weights: %v", weights)
+	}
+
+	for _, entry := range []string{"hsm", "hsm=0", "hsm=-1", "hsm=x"} {
+		if _, err := ParseKeyWeights([]string{entry}); err == nil {
+			t.Errorf("Expected error for %q", entry)
+		}
+	}
+}
+
+func TestNewKeySelector(t *testing.T) {
+	for _, name := range KeySelectors {
+		selector, err := NewKeySelector(name, nil)
+		if err != nil {
+			t.Fatalf("Failed to create %s selector: %v", name, err)
+		}
+		if selector.Name() != name {
+			t.Errorf("Expected selector %s, got %s", name, selector.Name())
+		}
+	}
+
+	if _, err := NewKeySelector("round-robin", nil); err == nil {
+		t.Errorf("Expected error for unknown selector")
+	}
+}
+
+func TestLeastSignaturesSelector(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 3)
+
+	database.gorm.Exec("UPDATE signing_keys SET signature_count = 100 - id, last_used = now()")
+
+	selector, _ := NewKeySelector(KeySelectLeastSignatures, nil)
+	key, err := database.AcquireSigningKey(context.Background(), selector, "worker", time.Minute, nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire key: %v", err)
+	}
+	if key.ID != 3 {
+		t.Errorf("Expected key 3 with the fewest signatures, got key %d", key.ID)
+	}
+}
+
+func TestSimulateKeySelection(t *testing.T) {
+	database := newTestDB(t)
+	insertTestKeys(t, database, 4)
+
+	database.gorm.Model(&models.SigningKey{}).Where("id = ?", 1).Update("label", "heavy")
+
+	ctx := context.Background()
+
+	lru, _ := NewKeySelector(KeySelectLRU, nil)
+	counts, err := database.SimulateKeySelection(ctx, lru, 40, 10)
+	if err != nil {
+		t.Fatalf("Failed to simulate LRU selection: %v", err)
+	}
+	for keyID := 1; keyID <= 4; keyID++ {
+		if counts[keyID] != 10 {
+			t.Errorf("Expected LRU to give key %d 10 batches, got %d", keyID, counts[keyID])
+		}
+	}
+
+	weighted, _ := NewKeySelector(KeySelectWeighted, map[string]float64{"heavy": 50})
+	counts, err = database.SimulateKeySelection(ctx, weighted, 200, 10)
+	if err != nil {
+		t.Fatalf("Failed to simulate weighted selection: %v", err)
+	}
+	if counts[1] < 150 {
+		t.Errorf("Expected the heavy key to get most batches, got %v", counts)
+	}
+
+	var used int64
+	database.gorm.Model(&models.SigningKey{}).Where("last_used IS NOT NULL OR signature_count > 0").Count(&used)
+	if used != 0 {
+		t.Errorf("Expected the simulation to leave the pool untouched, %d keys changed", used)
+	}
+}
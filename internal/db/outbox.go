@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func insertOutboxMessage(tx *gorm.DB, msg *models.OutboxMessage) error {
+	msg.CreatedAt = time.Now()
+
+	if err := tx.Create(msg).Error; err != nil {
+		return fmt.Errorf("failed to insert outbox message %s: %w", msg.MessageID, err)
+	}
+
+	return nil
+}
+
+// RelayOutbox publishes up to limit unpublished outbox messages, oldest
+// first, and marks them published, returning how many were. It stops at the
+// first message publish fails on, recording the attempt and error on it and
+// returning the error. A message that has failed maxAttempts times is
+// dead-lettered instead: it gets FailedAt, is never tried again and is
+// returned in dead, and the relay moves on to the next message. A
+// maxAttempts of 0 retries forever. Dead-lettered batches stay QUEUED until
+// the reaper requeues their records.
+//
+// Messages are not strictly ordered: concurrent relays skip each other's
+// locked rows, and a dead-lettered message is overtaken by the ones after
+// it.
+//
+// The messages stay locked with FOR UPDATE SKIP LOCKED until they are marked,
+// so concurrent relays never publish the same message. A relay that dies
+// after publishing but before committing leaves the message unpublished, and
+// it is published again: delivery is at least once, and publish must
+// deduplicate by MessageID.
+func (db *DB) RelayOutbox(ctx context.Context, limit, maxAttempts int, publish func(msg *models.OutboxMessage) error) (int, []*models.OutboxMessage, error) {
+	published := 0
+	var dead []*models.OutboxMessage
+	var publishErr error
+
+	err := db.gorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []*models.OutboxMessage
+
+		result := tx.
+			Where("published_at IS NULL AND failed_at IS NULL").
+			Order("id").
+			Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Find(&messages)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, msg := range messages {
+			if publishErr = publish(msg); publishErr != nil {
+				updates := map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": publishErr.Error(),
+				}
+
+				if maxAttempts <= 0 || msg.Attempts+1 < maxAttempts {
+					return tx.Model(msg).Updates(updates).Error
+				}
+
+				failedAt := time.Now()
+				updates["failed_at"] = failedAt
+				if err := tx.Model(msg).Updates(updates).Error; err != nil {
+					return err
+				}
+
+				msg.FailedAt = &failedAt
+				msg.Attempts++
+				msg.LastError = publishErr.Error()
+				dead = append(dead, msg)
+				publishErr = nil
+				continue
+			}
+
+			result := tx.Model(msg).Updates(map[string]interface{}{
+				"published_at": time.Now(),
+				"attempts":     gorm.Expr("attempts + 1"),
+				"last_error":   "",
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			published++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to relay outbox: %w", err)
+	}
+
+	if publishErr != nil {
+		return published, dead, fmt.Errorf("failed to relay outbox: %w", publishErr)
+	}
+
+	return published, dead, nil
+}
+
+// CountUnpublishedOutbox returns how many outbox messages wait to be
+// published, not counting dead-lettered ones.
+func (db *DB) CountUnpublishedOutbox(ctx context.Context) (int64, error) {
+	var count int64
+
+	result := db.gorm.WithContext(ctx).
+		Model(&models.OutboxMessage{}).
+		Where("published_at IS NULL AND failed_at IS NULL").
+		Count(&count)
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to count unpublished outbox messages: %w", result.Error)
+	}
+
+	return count, nil
+}
+
+// PruneOutbox deletes messages published before the given time.
+func (db *DB) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	result := db.gorm.WithContext(ctx).
+		Where("published_at < ?", before).
+		Delete(&models.OutboxMessage{})
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
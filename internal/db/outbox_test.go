@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func insertTestRecords(tb testing.TB, database *DB, count int) {
+	tb.Helper()
+
+	records := make([]*models.Record, count)
+	for i := range records {
+		records[i] = &models.Record{Payload: []byte(`{}`)}
+	}
+	if err := database.InsertRecords(records); err != nil {
+		tb.Fatalf("Failed to insert records: %v", err)
+	}
+}
+
+func TestRelayOutboxStopsAtFailure(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 3)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := database.ClaimPendingRecords(ctx, 1, testOutboxMessage); err != nil {
+			t.Fatalf("Failed to claim records: %v", err)
+		}
+	}
+
+	var published []string
+	failOn := 2
+	publish := func(msg *models.OutboxMessage) error {
+		if msg.ID == failOn {
+			return errors.New("nats unavailable")
+		}
+		published = append(published, msg.MessageID)
+		return nil
+	}
+
+	n, dead, err := database.RelayOutbox(ctx, 10, 0, publish)
+	if err == nil {
+		t.Fatalf("Expected the publish error to be returned")
+	}
+	if n != 1 || len(published) != 1 {
+		t.Fatalf("Expected only the message before the failure to be published, got %d", n)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("Expected no dead-lettered messages, got %d", len(dead))
+	}
+
+	var failed models.OutboxMessage
+	database.gorm.First(&failed, failOn)
+	if failed.PublishedAt != nil || failed.Attempts != 1 || failed.LastError == "" {
+		t.Errorf("Expected the failed message to record the attempt, got %+v", failed)
+	}
+
+	failOn = 0
+	if n, _, err := database.RelayOutbox(ctx, 10, 0, publish); err != nil || n != 2 {
+		t.Fatalf("Expected the remaining 2 messages to be published, got %d: %v", n, err)
+	}
+
+	if count, err := database.CountUnpublishedOutbox(ctx); err != nil || count != 0 {
+		t.Errorf("Expected an empty outbox, got %d: %v", count, err)
+	}
+}
+
+func TestRelayOutboxDeadLettersAfterMaxAttempts(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 2)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := database.ClaimPendingRecords(ctx, 1, testOutboxMessage); err != nil {
+			t.Fatalf("Failed to claim records: %v", err)
+		}
+	}
+
+	var published []int
+	publish := func(msg *models.OutboxMessage) error {
+		if msg.ID == 1 {
+			return errors.New("message too large")
+		}
+		published = append(published, msg.ID)
+		return nil
+	}
+
+	// The first pass stops at the failing message, the second gives up on
+	// it and publishes the one behind it.
+	if n, dead, err := database.RelayOutbox(ctx, 10, 2, publish); err == nil || n != 0 || len(dead) != 0 {
+		t.Fatalf("Expected the first attempt to fail, got %d published, %d dead: %v", n, len(dead), err)
+	}
+
+	n, dead, err := database.RelayOutbox(ctx, 10, 2, publish)
+	if err != nil {
+		t.Fatalf("Failed to relay outbox: %v", err)
+	}
+	if n != 1 || len(published) != 1 || published[0] != 2 {
+		t.Errorf("Expected message 2 published, got %v", published)
+	}
+	if len(dead) != 1 || dead[0].ID != 1 || dead[0].Attempts != 2 || dead[0].FailedAt == nil {
+		t.Fatalf("Expected message 1 dead-lettered after 2 attempts, got %+v", dead)
+	}
+
+	if n, dead, err := database.RelayOutbox(ctx, 10, 2, publish); err != nil || n != 0 || len(dead) != 0 {
+		t.Errorf("Expected the dead-lettered message to be skipped, got %d published, %d dead: %v", n, len(dead), err)
+	}
+	if count, err := database.CountUnpublishedOutbox(ctx); err != nil || count != 0 {
+		t.Errorf("Expected no messages waiting, got %d: %v", count, err)
+	}
+}
+
+func TestRelayOutboxPublishesOnce(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 200)
+
+	ctx := context.Background()
+	for {
+		records, err := database.ClaimPendingRecords(ctx, 5, testOutboxMessage)
+		if err != nil {
+			t.Fatalf("Failed to claim records: %v", err)
+		}
+		if len(records) == 0 {
+			break
+		}
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	publish := func(msg *models.OutboxMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[msg.MessageID]++
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, _, err := database.RelayOutbox(ctx, 3, 0, publish)
+				if err != nil {
+					t.Errorf("Relay failed: %v", err)
+					return
+				}
+				if n == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 40 {
+		t.Errorf("Expected 40 messages published, got %d", len(seen))
+	}
+	for id, count := range seen {
+		if count != 1 {
+			t.Errorf("Message %s published %d times", id, count)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+// PendingCursor claims PENDING records batch by batch in ID order. Each
+// claim continues after the last record the cursor claimed, so it reads only
+// the next page of the pending index instead of starting from the lowest ID
+// again.
+type PendingCursor struct {
+	db        *DB
+	batchSize int
+	afterID   int
+}
+
+// NewPendingCursor returns a cursor that claims batchSize records at a time,
+// starting from the lowest pending ID.
+func (db *DB) NewPendingCursor(batchSize int) *PendingCursor {
+	return &PendingCursor{db: db, batchSize: batchSize}
+}
+
+// Next claims the next batch as ClaimPendingRecords does and returns no
+// records once none are pending. When it reaches the end of the table it
+// looks once more from the start, for records that became PENDING behind
+// the cursor: requeued by the reaper or retried, or skipped while another
+// dispatcher held them and then rolled back.
+func (c *PendingCursor) Next(ctx context.Context, message func(batchID string, records []*models.Record) (*models.OutboxMessage, error)) ([]*models.Record, error) {
+	records, err := c.db.claimPendingRecords(ctx, c.afterID, c.batchSize, message)
+	if err == nil && len(records) == 0 && c.afterID > 0 {
+		c.afterID = 0
+		records, err = c.db.claimPendingRecords(ctx, c.afterID, c.batchSize, message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 {
+		c.afterID = records[len(records)-1].ID
+	}
+
+	return records, nil
+}
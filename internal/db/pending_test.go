@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+	"gorm.io/gorm"
+)
+
+func TestPendingCursorClaimsEveryRecord(t *testing.T) {
+	database := newTestDB(t)
+	insertTestRecords(t, database, 5)
+
+	ctx := context.Background()
+	cursor := database.NewPendingCursor(2)
+
+	var claimed []int
+	for {
+		records, err := cursor.Next(ctx, testOutboxMessage)
+		if err != nil {
+			t.Fatalf("Failed to claim records: %v", err)
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			claimed = append(claimed, record.ID)
+		}
+
+		// The first record is requeued behind the cursor once it has
+		// moved past it.
+		if len(claimed) == 4 {
+			database.gorm.Model(&models.Record{}).Where("id = ?", claimed[0]).Update("status", models.RecordStatusPending)
+		}
+	}
+
+	if len(claimed) != 6 {
+		t.Fatalf("Expected 5 records and the requeued one claimed, got %v", claimed)
+	}
+	for i := 1; i < 5; i++ {
+		if claimed[i] <= claimed[i-1] {
+			t.Errorf("Expected records claimed in ID order, got %v", claimed)
+		}
+	}
+	if claimed[5] != claimed[0] {
+		t.Errorf("Expected record %d claimed again after the cursor wrapped, got %v", claimed[0], claimed)
+	}
+}
+
+func TestClaimQueryUsesPendingIndex(t *testing.T) {
+	database := newTestDB(t)
+
+	err := database.gorm.Exec(`
+		INSERT INTO records (payload, status)
+		SELECT '{}', CASE WHEN g % 100 = 0 THEN ? ELSE ? END
+		FROM generate_series(1, 100000) AS g`,
+		models.RecordStatusPending, models.RecordStatusSigned).Error
+	if err != nil {
+		t.Fatalf("Failed to insert records: %v", err)
+	}
+	database.gorm.Exec("ANALYZE records")
+
+	var query []int
+	stmt := pendingRecordIDs(database.gorm.Session(&gorm.Session{DryRun: true}), 0, 100).Find(&query).Statement
+
+	// The claim runs as a prepared statement, so the generic plan, which
+	// cannot see the cursor position, must use the index too.
+	err = database.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET LOCAL plan_cache_mode = force_generic_plan").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("PREPARE claim_pending AS " + stmt.SQL.String()).Error; err != nil {
+			return err
+		}
+		defer tx.Exec("DEALLOCATE claim_pending")
+
+		var plan []string
+		if err := tx.Raw("EXPLAIN EXECUTE claim_pending(?, ?)", stmt.Vars...).Scan(&plan).Error; err != nil {
+			return err
+		}
+
+		if !strings.Contains(strings.Join(plan, "\n"), "idx_records_pending") {
+			t.Errorf("Expected the claim query to use idx_records_pending, got plan:\n%s", strings.Join(plan, "\n"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to explain claim query: %v", err)
+	}
+}
+
+// BenchmarkClaimPendingRecords claims batches of 100 from a table of 10M
+// records, the first 9M already SIGNED, with the cursor and with a claim
+// that starts from the lowest ID each time, with and without the partial
+// index on pending records:
+//
+//	TEST_DATABASE_URL=... go test ./internal/db -run '^$' -bench ClaimPendingRecords -timeout 1h
+func BenchmarkClaimPendingRecords(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping 10M-row benchmark in short mode")
+	}
+
+	const (
+		total     = 10_000_000
+		pending   = 1_000_000
+		batchSize = 100
+	)
+
+	database := newTestDB(b)
+	ctx := context.Background()
+
+	err := database.gorm.Exec(`
+		INSERT INTO records (payload, status)
+		SELECT '{}', CASE WHEN g <= ? THEN ? ELSE ? END
+		FROM generate_series(1, ?) AS g`,
+		total-pending, models.RecordStatusSigned, models.RecordStatusPending, total).Error
+	if err != nil {
+		b.Fatalf("Failed to insert records: %v", err)
+	}
+	database.gorm.Exec("ANALYZE records")
+
+	reset := func(b *testing.B) {
+		b.Helper()
+		if err := database.gorm.Exec("UPDATE records SET status = ?, queued_at = NULL WHERE status = ?", models.RecordStatusPending, models.RecordStatusQueued).Error; err != nil {
+			b.Fatalf("Failed to reset records: %v", err)
+		}
+		database.gorm.Exec("DELETE FROM outbox_messages")
+	}
+
+	claims := []struct {
+		name  string
+		claim func() func(ctx context.Context) ([]*models.Record, error)
+	}{
+		{"cursor", func() func(ctx context.Context) ([]*models.Record, error) {
+			cursor := database.NewPendingCursor(batchSize)
+			return func(ctx context.Context) ([]*models.Record, error) {
+				return cursor.Next(ctx, testOutboxMessage)
+			}
+		}},
+		{"from-start", func() func(ctx context.Context) ([]*models.Record, error) {
+			return func(ctx context.Context) ([]*models.Record, error) {
+				return database.ClaimPendingRecords(ctx, batchSize, testOutboxMessage)
+			}
+		}},
+	}
+
+	for _, index := range []bool{true, false} {
+		name := "pending-index"
+		if !index {
+			name = "no-index"
+			database.gorm.Exec("DROP INDEX idx_records_pending")
+		}
+
+		for _, c := range claims {
+			b.Run(name+"/"+c.name, func(b *testing.B) {
+				reset(b)
+				claim := c.claim()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					records, err := claim(ctx)
+					if err != nil {
+						b.Fatalf("Failed to claim records: %v", err)
+					}
+					if len(records) == 0 {
+						b.StopTimer()
+						reset(b)
+						b.StartTimer()
+					}
+				}
+				b.ReportMetric(float64(batchSize*b.N)/b.Elapsed().Seconds(), "records/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// intArray passes a slice as a single Postgres array parameter in text
+// form, to be cast with ?::int[]. GORM would otherwise expand it into a
+// list of parameters.
+type intArray []int
+
+func (a intArray) Value() (driver.Value, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(v))
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+// byteaArray passes a slice of byte strings as a single Postgres array
+// parameter in text form, to be cast with ?::bytea[]. Each element is
+// written in the hex bytea format.
+type byteaArray [][]byte
+
+func (a byteaArray) Value() (driver.Value, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`"\\x`)
+		b.WriteString(hex.EncodeToString(v))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestArrayValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		array driver.Valuer
+		want  string
+	}{
+		{"empty ints", intArray(nil), `{}`},
+		{"ints", intArray{1, -2, 30}, `{1,-2,30}`},
+		{"empty byteas", byteaArray(nil), `{}`},
+		{"byteas", byteaArray{{0x01, 0xab}, {}}, `{"\\x01ab","\\x"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.array.Value()
+			if err != nil {
+				t.Fatalf("Value() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Value() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
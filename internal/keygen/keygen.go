@@ -0,0 +1,89 @@
+package keygen
+
+import (
+	"fmt"
+
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+// Batch is a set of freshly generated signing keys. The private keys are kept
+// alongside so they can be wrapped once the keys have database IDs.
+type Batch struct {
+	Keys    []*models.SigningKey
+	signers map[*models.SigningKey]crypto.Signer
+}
+
+func ParseAlgorithms(names []string) ([]crypto.Algorithm, error) {
+	algorithms := make([]crypto.Algorithm, 0, len(names))
+	for _, name := range names {
+		alg, err := crypto.ParseAlgorithm(name)
+		if err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, alg)
+	}
+	return algorithms, nil
+}
+
+// Generate assigns algorithms round-robin so a mixed pool is evenly split.
+// Each key gets maxSignatures as its quota (zero for none) and label, which
+// weighted key selection uses.
+func Generate(count int, algorithms []crypto.Algorithm, maxSignatures int64, label string) (*Batch, error) {
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("no key algorithms configured")
+	}
+
+	batch := &Batch{
+		Keys:    make([]*models.SigningKey, 0, count),
+		signers: make(map[*models.SigningKey]crypto.Signer, count),
+	}
+
+	for i := 0; i < count; i++ {
+		key, signer, err := generateKey(algorithms[i%len(algorithms)])
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key %d: %w", i+1, err)
+		}
+		key.MaxSignatures = maxSignatures
+		key.Label = label
+		batch.Keys = append(batch.Keys, key)
+		batch.signers[key] = signer
+	}
+
+	return batch, nil
+}
+
+// Wrapper returns the callback that encrypts each key in the batch once it
+// has been assigned an ID.
+func (b *Batch) Wrapper(encryptor *crypto.KeyEncryptor) func(key *models.SigningKey) error {
+	return func(key *models.SigningKey) error {
+		signer, ok := b.signers[key]
+		if !ok {
+			return fmt.Errorf("key is not part of this batch")
+		}
+
+		pkcs8Key, err := crypto.MarshalSigner(signer)
+		if err != nil {
+			return err
+		}
+		defer clear(pkcs8Key)
+
+		return encryptor.WrapKey(key, pkcs8Key)
+	}
+}
+
+func generateKey(alg crypto.Algorithm) (*models.SigningKey, crypto.Signer, error) {
+	signer, err := crypto.GenerateSigner(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := &models.SigningKey{
+		PublicKey: signer.PublicKey(),
+		Algorithm: string(alg),
+		InUse:     false,
+		State:     models.KeyStateActive,
+	}
+
+	return key, signer, nil
+}
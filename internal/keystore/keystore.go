@@ -0,0 +1,92 @@
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/arleyar/go-record-signer/internal/db"
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/keyservice"
+)
+
+// DBStore serves signing keys from the signing_keys table. Each key is
+// unwrapped on first use and kept in memory until Close.
+type DBStore struct {
+	db        *db.DB
+	encryptor *crypto.KeyEncryptor
+
+	mu      sync.Mutex
+	handles map[int]*crypto.KeyHandle
+}
+
+func NewDBStore(database *db.DB, encryptor *crypto.KeyEncryptor) *DBStore {
+	return &DBStore{
+		db:        database,
+		encryptor: encryptor,
+		handles:   make(map[int]*crypto.KeyHandle),
+	}
+}
+
+func (s *DBStore) Signer(ctx context.Context, keyID int) (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if handle, ok := s.handles[keyID]; ok {
+		return handle, nil
+	}
+
+	key, err := s.db.GetSigningKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, keyservice.ErrKeyNotFound
+	}
+
+	handle, err := s.encryptor.UnwrapKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap signing key %d: %w", keyID, err)
+	}
+
+	s.handles[keyID] = handle
+	return handle, nil
+}
+
+// Unseal unwraps every key in the table up front, so the store never needs
+// to touch the database or the encryption key again for known keys.
+func (s *DBStore) Unseal(ctx context.Context) (int, error) {
+	keys, err := s.db.GetWrappedSigningKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := s.handles[key.ID]; ok {
+			continue
+		}
+
+		handle, err := s.encryptor.UnwrapKey(key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unwrap signing key %d: %w", key.ID, err)
+		}
+
+		s.handles[key.ID] = handle
+	}
+
+	return len(s.handles), nil
+}
+
+// Close destroys every unwrapped key held by the store.
+func (s *DBStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for keyID, handle := range s.handles {
+		handle.Destroy()
+		delete(s.handles, keyID)
+	}
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Serve exposes the process's expvar variables as JSON at /debug/vars on
+// addr in the background. An empty addr disables the endpoint.
+func Serve(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		log.Printf("Serving metrics on %s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+}
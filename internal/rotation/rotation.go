@@ -0,0 +1,59 @@
+package rotation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+// Policy decides which active keys are due for retirement and how many new
+// keys keep the pool at PoolSize. A zero MaxAge or MaxSignatures disables
+// that trigger.
+type Policy struct {
+	PoolSize      int
+	MaxAge        time.Duration
+	MaxSignatures int64
+}
+
+type Plan struct {
+	// Retire maps key IDs to the reason they are retired.
+	Retire map[int]string
+	// Create is the number of new keys needed to refill the pool.
+	Create int
+}
+
+func (p Policy) Plan(active []*models.SigningKey, now time.Time) Plan {
+	plan := Plan{Retire: make(map[int]string)}
+
+	var kept []*models.SigningKey
+	for _, key := range active {
+		switch {
+		case p.MaxAge > 0 && now.Sub(key.CreatedAt) >= p.MaxAge:
+			plan.Retire[key.ID] = fmt.Sprintf("age %s reached limit %s", now.Sub(key.CreatedAt).Round(time.Second), p.MaxAge)
+		case p.MaxSignatures > 0 && key.SignatureCount >= p.MaxSignatures:
+			plan.Retire[key.ID] = fmt.Sprintf("%d signatures reached limit %d", key.SignatureCount, p.MaxSignatures)
+		default:
+			kept = append(kept, key)
+		}
+	}
+
+	if len(kept) > p.PoolSize {
+		sort.Slice(kept, func(i, j int) bool {
+			if !kept[i].CreatedAt.Equal(kept[j].CreatedAt) {
+				return kept[i].CreatedAt.Before(kept[j].CreatedAt)
+			}
+			return kept[i].ID < kept[j].ID
+		})
+
+		for _, key := range kept[:len(kept)-p.PoolSize] {
+			plan.Retire[key.ID] = fmt.Sprintf("pool size above %d", p.PoolSize)
+		}
+		kept = kept[len(kept)-p.PoolSize:]
+	}
+
+	plan.Create = max(p.PoolSize-len(kept), 0)
+
+	return plan
+}
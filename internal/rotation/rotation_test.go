@@ -0,0 +1,73 @@
+package rotation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	key := func(id int, age time.Duration, signatures int64) *models.SigningKey {
+		return &models.SigningKey{ID: id, CreatedAt: now.Add(-age), SignatureCount: signatures}
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		active []*models.SigningKey
+		retire []int
+		create int
+	}{
+		{
+			name:   "fills an empty pool",
+			policy: Policy{PoolSize: 3},
+			create: 3,
+		},
+		{
+			name:   "retires keys past max age",
+			policy: Policy{PoolSize: 2, MaxAge: 48 * time.Hour},
+			active: []*models.SigningKey{key(1, 72*time.Hour, 0), key(2, time.Hour, 0)},
+			retire: []int{1},
+			create: 1,
+		},
+		{
+			name:   "retires keys past max signatures",
+			policy: Policy{PoolSize: 2, MaxSignatures: 100},
+			active: []*models.SigningKey{key(1, time.Hour, 99), key(2, time.Hour, 100)},
+			retire: []int{2},
+			create: 1,
+		},
+		{
+			name:   "retires oldest keys above pool size",
+			policy: Policy{PoolSize: 2},
+			active: []*models.SigningKey{key(1, time.Hour, 0), key(2, 3*time.Hour, 0), key(3, 2*time.Hour, 0)},
+			retire: []int{2},
+		},
+		{
+			name:   "leaves a healthy pool alone",
+			policy: Policy{PoolSize: 2, MaxAge: 48 * time.Hour, MaxSignatures: 100},
+			active: []*models.SigningKey{key(1, time.Hour, 10), key(2, time.Hour, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := tt.policy.Plan(tt.active, now)
+
+			if len(plan.Retire) != len(tt.retire) {
+				t.Fatalf("Expected %d keys retired, got %v", len(tt.retire), plan.Retire)
+			}
+			for _, id := range tt.retire {
+				if _, ok := plan.Retire[id]; !ok {
+					t.Errorf("Expected key %d to be retired, got %v", id, plan.Retire)
+				}
+			}
+
+			if plan.Create != tt.create {
+				t.Errorf("Expected %d keys created, got %d", tt.create, plan.Create)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package canonical
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Scheme identifies how a payload was turned into the bytes that were signed.
+type Scheme string
+
+const (
+	// SchemeNone signs the compact JSON encoding the payload has in transit.
+	SchemeNone Scheme = "none"
+	// SchemeJCS signs the RFC 8785 JSON Canonicalization Scheme encoding.
+	SchemeJCS Scheme = "jcs"
+)
+
+func ParseScheme(s string) (Scheme, error) {
+	scheme := Scheme(strings.ToLower(strings.TrimSpace(s)))
+	switch scheme {
+	case SchemeNone, SchemeJCS:
+		return scheme, nil
+	case "":
+		return SchemeNone, nil
+	default:
+		return "", fmt.Errorf("unsupported canonicalization scheme: %q", s)
+	}
+}
+
+func Apply(scheme Scheme, payload []byte) ([]byte, error) {
+	switch scheme {
+	case SchemeNone, "":
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, payload); err != nil {
+			return nil, fmt.Errorf("failed to compact payload: %w", err)
+		}
+		return buf.Bytes(), nil
+	case SchemeJCS:
+		return JCS(payload)
+	default:
+		return nil, fmt.Errorf("unsupported canonicalization scheme: %q", scheme)
+	}
+}
+
+// JCS returns the RFC 8785 canonical form of a JSON document.
+func JCS(payload []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := writeValue(&buf, dec); err != nil {
+		return nil, fmt.Errorf("failed to canonicalize payload: %w", err)
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("failed to canonicalize payload: trailing data after JSON value")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeValue(buf *bytes.Buffer, dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	return writeToken(buf, dec, tok)
+}
+
+func writeToken(buf *bytes.Buffer, dec *json.Decoder, tok json.Token) error {
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			return writeObject(buf, dec)
+		case '[':
+			return writeArray(buf, dec)
+		default:
+			return fmt.Errorf("unexpected delimiter %q", v)
+		}
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s: %w", v, err)
+		}
+		s, err := formatNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case string:
+		writeString(buf, v)
+	default:
+		return fmt.Errorf("unexpected token %v", tok)
+	}
+	return nil
+}
+
+func writeArray(buf *bytes.Buffer, dec *json.Decoder) error {
+	buf.WriteByte('[')
+	for i := 0; ; i++ {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == json.Delim(']') {
+			break
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeToken(buf, dec, tok); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+type member struct {
+	key   string
+	sort  []uint16
+	value []byte
+}
+
+func writeObject(buf *bytes.Buffer, dec *json.Decoder) error {
+	var members []member
+	seen := make(map[string]bool)
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == json.Delim('}') {
+			break
+		}
+
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected object key %v", tok)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate object key %q", key)
+		}
+		seen[key] = true
+
+		var value bytes.Buffer
+		if err := writeValue(&value, dec); err != nil {
+			return err
+		}
+
+		members = append(members, member{
+			key:   key,
+			sort:  utf16.Encode([]rune(key)),
+			value: value.Bytes(),
+		})
+	}
+
+	// Keys are ordered by their UTF-16 code units, not by code points.
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i].sort, members[j].sort
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, m.key)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// formatNumber serializes f the way ECMAScript's Number.prototype.toString
+// does, as required by RFC 8785 section 3.2.2.3.
+func formatNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("number %v cannot be represented in JSON", f)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// Shortest round-tripping digits, e.g. "1.2345e+06".
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, expStr, _ := strings.Cut(s, "e")
+	exp, err := strconv.Atoi(expStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to format number %v: %w", f, err)
+	}
+
+	digits := strings.Replace(mantissa, ".", "", 1)
+	k := len(digits)
+	n := exp + 1
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k), nil
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:], nil
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits, nil
+	}
+
+	expSign := "+"
+	if n-1 < 0 {
+		expSign = "-"
+	}
+	out := sign + digits[:1]
+	if k > 1 {
+		out += "." + digits[1:]
+	}
+	return out + "e" + expSign + strconv.Itoa(abs(n-1)), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
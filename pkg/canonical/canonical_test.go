@@ -0,0 +1,104 @@
+package canonical
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJCS(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "whitespace and key order",
+			input: `{ "timestamp": 1700000000000000000, "id" : "abc" }`,
+			want:  `{"id":"abc","timestamp":1700000000000000000}`,
+		},
+		{
+			name:  "nested values",
+			input: `{"b":[3, {"z":null,"a":true}],"a":{"y":false,"x":"\u0041"}}`,
+			want:  `{"a":{"x":"A","y":false},"b":[3,{"a":true,"z":null}]}`,
+		},
+		{
+			name:  "utf16 key ordering",
+			input: `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			want:  "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{
+			name:  "string escaping",
+			input: `["\u001f\u0008\"\\\/<>&\u2028"]`,
+			want:  "[\"\\u001f\\b\\\"\\\\/<>&\u2028\"]",
+		},
+		{
+			name:  "rfc 8785 numbers",
+			input: `[56, 1e+30, 4.50, 2e-3, 0.000000000000000000000000001, -0, 333333333.33333329]`,
+			want:  `[56,1e+30,4.5,0.002,1e-27,0,333333333.3333333]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JCS([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("JCS failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("JCS mismatch\n got: %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJCSRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{
+		`{"a":1,"a":2}`,
+		`{"a":1} {"b":2}`,
+		`{"a":`,
+		`[1e400]`,
+	} {
+		if _, err := JCS([]byte(input)); err == nil {
+			t.Errorf("Expected error for %s", input)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{1, "1"},
+		{-1.5, "-1.5"},
+		{1e20, "100000000000000000000"},
+		{1e21, "1e+21"},
+		{123456789012345680000, "123456789012345680000"},
+		{0.000001, "0.000001"},
+		{0.0000001, "1e-7"},
+		{1.2345e-10, "1.2345e-10"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{5e-324, "5e-324"},
+		{9007199254740993, "9007199254740992"},
+	}
+
+	for _, tt := range tests {
+		got, err := formatNumber(tt.in)
+		if err != nil {
+			t.Fatalf("formatNumber(%v) failed: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("formatNumber(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyNone(t *testing.T) {
+	got, err := Apply(SchemeNone, []byte(`{"id": "abc", "timestamp": 1}`))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if string(got) != `{"id":"abc","timestamp":1}` {
+		t.Errorf("Unexpected compact payload: %s", got)
+	}
+}
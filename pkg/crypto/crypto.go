@@ -3,25 +3,56 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
 )
 
+const DefaultKEKVersion = 1
+
+// Wrap formats recorded in signing_keys.wrap_format. Legacy ciphertexts were
+// sealed without additional authenticated data; bound ciphertexts carry the
+// key ID, algorithm and KEK version as AAD so blobs cannot be moved between
+// rows.
+const (
+	WrapFormatLegacy = 1
+	WrapFormatBound  = 2
+)
+
+// KeyEncryptor wraps private keys with AES-GCM under a set of versioned key
+// encryption keys (KEKs). New ciphertexts always use the active version;
+// older versions are kept so existing keys can still be decrypted.
 type KeyEncryptor struct {
-	encryptionKey []byte
+	keks          map[int][]byte
+	activeVersion int
 }
 
 func NewKeyEncryptor(encryptionKey []byte) (*KeyEncryptor, error) {
-	if len(encryptionKey) != 32 {
-		return nil, errors.New("encryption key must be 32 bytes (256 bits)")
+	return NewVersionedKeyEncryptor(map[int][]byte{DefaultKEKVersion: encryptionKey}, DefaultKEKVersion)
+}
+
+func NewVersionedKeyEncryptor(keks map[int][]byte, activeVersion int) (*KeyEncryptor, error) {
+	if _, ok := keks[activeVersion]; !ok {
+		return nil, fmt.Errorf("active encryption key version %d is not configured", activeVersion)
+	}
+
+	copied := make(map[int][]byte, len(keks))
+	for version, kek := range keks {
+		if version <= 0 {
+			return nil, fmt.Errorf("invalid encryption key version %d", version)
+		}
+		if len(kek) != 32 {
+			return nil, fmt.Errorf("encryption key version %d must be 32 bytes (256 bits)", version)
+		}
+		copied[version] = kek
 	}
 
 	return &KeyEncryptor{
-		encryptionKey: encryptionKey,
+		keks:          copied,
+		activeVersion: activeVersion,
 	}, nil
 }
 
@@ -33,15 +64,30 @@ func GenerateEncryptionKey() ([]byte, error) {
 	return key, nil
 }
 
+func (e *KeyEncryptor) ActiveVersion() int {
+	return e.activeVersion
+}
+
 func (e *KeyEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(e.encryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
-	}
+	return e.EncryptWithVersion(e.activeVersion, plaintext)
+}
 
-	aesGCM, err := cipher.NewGCM(block)
+func (e *KeyEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	return e.DecryptWithVersion(e.activeVersion, ciphertext)
+}
+
+func (e *KeyEncryptor) EncryptWithVersion(version int, plaintext []byte) ([]byte, error) {
+	return e.seal(version, plaintext, nil)
+}
+
+func (e *KeyEncryptor) DecryptWithVersion(version int, ciphertext []byte) ([]byte, error) {
+	return e.open(version, ciphertext, nil)
+}
+
+func (e *KeyEncryptor) seal(version int, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aesGCM, err := e.gcm(version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
 
 	nonce := make([]byte, aesGCM.NonceSize())
@@ -49,19 +95,14 @@ func (e *KeyEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	ciphertext := aesGCM.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := aesGCM.Seal(nonce, nonce, plaintext, additionalData)
 	return ciphertext, nil
 }
 
-func (e *KeyEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(e.encryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	aesGCM, err := cipher.NewGCM(block)
+func (e *KeyEncryptor) open(version int, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aesGCM, err := e.gcm(version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
 
 	nonceSize := aesGCM.NonceSize()
@@ -70,7 +111,7 @@ func (e *KeyEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
@@ -78,20 +119,97 @@ func (e *KeyEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-func (e *KeyEncryptor) SignPayload(privateKeyBytes []byte, payload []byte) ([]byte, error) {
-	decryptedKey, err := e.Decrypt(privateKeyBytes)
+func (e *KeyEncryptor) gcm(version int) (cipher.AEAD, error) {
+	kek, ok := e.keks[version]
+	if !ok {
+		return nil, fmt.Errorf("encryption key version %d is not configured", version)
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return aesGCM, nil
+}
+
+// WrapKey encrypts a PKCS#8 private key under the active KEK, binding the
+// key's ID, algorithm and KEK version as AAD, and stores the result on key.
+// The key must already have its database ID.
+func (e *KeyEncryptor) WrapKey(key *models.SigningKey, pkcs8Key []byte) error {
+	if key.ID == 0 {
+		return errors.New("signing key must be stored before its private key can be wrapped")
+	}
+
+	encryptedKey, err := e.seal(e.activeVersion, pkcs8Key, keyAAD(key.ID, key.Algorithm, e.activeVersion))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		return fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	key.PrivateKey = encryptedKey
+	key.KEKVersion = e.activeVersion
+	key.WrapFormat = WrapFormatBound
+	return nil
+}
+
+// RewrapKey re-encrypts key under the active KEK in the bound format. It
+// reports false when the key is already wrapped that way.
+func (e *KeyEncryptor) RewrapKey(key *models.SigningKey) (bool, error) {
+	if key.KEKVersion == e.activeVersion && key.WrapFormat == WrapFormatBound {
+		return false, nil
 	}
 
-	privateKey, err := x509.ParsePKCS8PrivateKey(decryptedKey)
+	pkcs8Key, err := e.unwrap(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return false, err
 	}
+	defer clear(pkcs8Key)
 
-	if key, ok := privateKey.(ed25519.PrivateKey); ok {
-		return ed25519.Sign(key, payload), nil
-	} else {
-		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	if err := e.WrapKey(key, pkcs8Key); err != nil {
+		return false, err
 	}
+
+	return true, nil
+}
+
+func (e *KeyEncryptor) unwrap(key *models.SigningKey) ([]byte, error) {
+	version := key.KEKVersion
+	if version == 0 {
+		version = DefaultKEKVersion
+	}
+
+	var additionalData []byte
+	switch key.WrapFormat {
+	case WrapFormatLegacy, 0:
+	case WrapFormatBound:
+		additionalData = keyAAD(key.ID, key.Algorithm, version)
+	default:
+		return nil, fmt.Errorf("unsupported wrap format %d for private key %d", key.WrapFormat, key.ID)
+	}
+
+	pkcs8Key, err := e.open(version, key.PrivateKey, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key %d: %w", key.ID, err)
+	}
+
+	return pkcs8Key, nil
+}
+
+func keyAAD(keyID int, algorithm string, kekVersion int) []byte {
+	return []byte(fmt.Sprintf("go-record-signer/signing-key;id=%d;alg=%s;kek=%d", keyID, algorithm, kekVersion))
+}
+
+func (e *KeyEncryptor) SignPayload(key *models.SigningKey, payload []byte) ([]byte, error) {
+	handle, err := e.UnwrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Destroy()
+
+	return handle.Sign(payload)
 }
@@ -32,3 +32,69 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Errorf("Decrypted data does not match original data")
 	}
 }
+
+func TestRewrapKeyToNewVersion(t *testing.T) {
+	oldKEK, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate encryption key: %v", err)
+	}
+	newKEK, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate encryption key: %v", err)
+	}
+
+	oldEncryptor, err := NewKeyEncryptor(oldKEK)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	key, _ := newTestKey(t, oldEncryptor, AlgorithmEd25519)
+	key.KEKVersion = oldEncryptor.ActiveVersion()
+
+	encryptor, err := NewVersionedKeyEncryptor(map[int][]byte{1: oldKEK, 2: newKEK}, 2)
+	if err != nil {
+		t.Fatalf("Failed to create versioned encryptor: %v", err)
+	}
+
+	changed, err := encryptor.RewrapKey(key)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if !changed || key.KEKVersion != 2 {
+		t.Fatalf("Expected key to be rewrapped to version 2, got changed=%v version=%d", changed, key.KEKVersion)
+	}
+
+	changed, err = encryptor.RewrapKey(key)
+	if err != nil || changed {
+		t.Errorf("Expected second rewrap to be a no-op, got changed=%v err=%v", changed, err)
+	}
+
+	if _, err := oldEncryptor.UnwrapKey(key); err == nil {
+		t.Errorf("Expected old-only encryptor to fail on version 2 key")
+	}
+
+	newOnly, err := NewVersionedKeyEncryptor(map[int][]byte{2: newKEK}, 2)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	handle, err := newOnly.UnwrapKey(key)
+	if err != nil {
+		t.Fatalf("Failed to unwrap rewrapped key without old KEK: %v", err)
+	}
+	handle.Destroy()
+}
+
+func TestNewVersionedKeyEncryptorRequiresActiveVersion(t *testing.T) {
+	kek, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate encryption key: %v", err)
+	}
+
+	if _, err := NewVersionedKeyEncryptor(map[int][]byte{1: kek}, 2); err == nil {
+		t.Errorf("Expected error when active version has no key")
+	}
+	if _, err := NewVersionedKeyEncryptor(map[int][]byte{1: kek[:16]}, 1); err == nil {
+		t.Errorf("Expected error for short key")
+	}
+}
@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+var ErrKeyDestroyed = errors.New("signing key has been destroyed")
+
+// KeySigner is a Signer for one leased key. Destroy ends its use and releases
+// any key material it holds.
+type KeySigner interface {
+	Signer
+	Destroy()
+}
+
+// SignerSource opens a KeySigner for a key acquired from the pool. The
+// KeyEncryptor unwraps the key locally; remote implementations sign by key ID
+// without the private key ever reaching the caller.
+type SignerSource interface {
+	OpenSigner(ctx context.Context, key *models.SigningKey) (KeySigner, error)
+}
+
+// KeyHandle is a decrypted signing key held in memory for the duration of a
+// key lease, so a whole batch is signed with a single decrypt. Destroy must
+// be called when the lease ends.
+type KeyHandle struct {
+	mu        sync.RWMutex
+	signer    Signer
+	pkcs8     []byte
+	destroyed bool
+}
+
+func (e *KeyEncryptor) UnwrapKey(key *models.SigningKey) (*KeyHandle, error) {
+	decryptedKey, err := e.unwrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ParseSigner(decryptedKey)
+	if err != nil {
+		clear(decryptedKey)
+		return nil, err
+	}
+
+	handle := &KeyHandle{signer: signer, pkcs8: decryptedKey}
+
+	if key.Algorithm != "" && Algorithm(key.Algorithm) != signer.Algorithm() {
+		handle.Destroy()
+		return nil, fmt.Errorf("key %d is stored as %s but decrypts to %s", key.ID, key.Algorithm, signer.Algorithm())
+	}
+
+	return handle, nil
+}
+
+func (e *KeyEncryptor) OpenSigner(ctx context.Context, key *models.SigningKey) (KeySigner, error) {
+	return e.UnwrapKey(key)
+}
+
+func (h *KeyHandle) Algorithm() Algorithm {
+	return h.signer.Algorithm()
+}
+
+func (h *KeyHandle) PublicKey() []byte {
+	return h.signer.PublicKey()
+}
+
+func (h *KeyHandle) Sign(payload []byte) ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.destroyed {
+		return nil, ErrKeyDestroyed
+	}
+
+	return h.signer.Sign(payload)
+}
+
+// Destroy overwrites the decrypted key material. It is best effort: the Go
+// runtime may still hold copies made by the standard library.
+func (h *KeyHandle) Destroy() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.destroyed {
+		return
+	}
+	h.destroyed = true
+
+	clear(h.pkcs8)
+
+	switch s := h.signer.(type) {
+	case *ed25519Signer:
+		clear(s.key)
+	case *ecdsaSigner:
+		zeroBigInt(s.key.D)
+	case *rsaPSSSigner:
+		zeroRSAKey(s.key)
+	}
+}
+
+func zeroRSAKey(key *rsa.PrivateKey) {
+	zeroBigInt(key.D)
+	for _, prime := range key.Primes {
+		zeroBigInt(prime)
+	}
+	zeroBigInt(key.Precomputed.Dp)
+	zeroBigInt(key.Precomputed.Dq)
+	zeroBigInt(key.Precomputed.Qinv)
+}
+
+func zeroBigInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	clear(n.Bits())
+	n.SetInt64(0)
+}
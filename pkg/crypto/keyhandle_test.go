@@ -0,0 +1,232 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+func newTestKey(tb testing.TB, encryptor *KeyEncryptor, alg Algorithm) (*models.SigningKey, Signer) {
+	tb.Helper()
+
+	signer, err := GenerateSigner(alg)
+	if err != nil {
+		tb.Fatalf("Failed to generate signer: %v", err)
+	}
+
+	pkcs8Key, err := MarshalSigner(signer)
+	if err != nil {
+		tb.Fatalf("Failed to marshal signer: %v", err)
+	}
+
+	key := &models.SigningKey{
+		ID:        1,
+		PublicKey: signer.PublicKey(),
+		Algorithm: string(alg),
+	}
+
+	if err := encryptor.WrapKey(key, pkcs8Key); err != nil {
+		tb.Fatalf("Failed to wrap key: %v", err)
+	}
+
+	return key, signer
+}
+
+func newTestEncryptor(tb testing.TB) *KeyEncryptor {
+	tb.Helper()
+
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		tb.Fatalf("Failed to generate encryption key: %v", err)
+	}
+
+	encryptor, err := NewKeyEncryptor(key)
+	if err != nil {
+		tb.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	return encryptor
+}
+
+func TestKeyHandleSignAndDestroy(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+	payload := []byte(`{"id":1}`)
+
+	for _, alg := range allAlgorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			key, _ := newTestKey(t, encryptor, alg)
+
+			handle, err := encryptor.UnwrapKey(key)
+			if err != nil {
+				t.Fatalf("Failed to unwrap key: %v", err)
+			}
+
+			signature, err := handle.Sign(payload)
+			if err != nil {
+				t.Fatalf("Signing failed: %v", err)
+			}
+
+			if err := Verify(alg, key.PublicKey, payload, signature); err != nil {
+				t.Errorf("Expected valid signature, got: %v", err)
+			}
+
+			pkcs8Key := handle.pkcs8
+			handle.Destroy()
+
+			for _, b := range pkcs8Key {
+				if b != 0 {
+					t.Fatalf("Expected decrypted key bytes to be zeroed")
+				}
+			}
+
+			if _, err := handle.Sign(payload); !errors.Is(err, ErrKeyDestroyed) {
+				t.Errorf("Expected ErrKeyDestroyed after Destroy, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnwrapKeyAlgorithmMismatch(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+
+	signer, err := GenerateSigner(AlgorithmEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate signer: %v", err)
+	}
+
+	pkcs8Key, err := MarshalSigner(signer)
+	if err != nil {
+		t.Fatalf("Failed to marshal signer: %v", err)
+	}
+
+	// The bound format would already reject a changed algorithm column, so
+	// use a wrap with a mislabelled algorithm to reach the parse check.
+	key := &models.SigningKey{ID: 1, Algorithm: string(AlgorithmECDSAP256)}
+	if err := encryptor.WrapKey(key, pkcs8Key); err != nil {
+		t.Fatalf("Failed to wrap key: %v", err)
+	}
+
+	if _, err := encryptor.UnwrapKey(key); err == nil {
+		t.Errorf("Expected error when stored algorithm does not match key material")
+	}
+}
+
+// BenchmarkSignBatch compares decrypting the key for every record with
+// unwrapping it once per batch.
+func BenchmarkSignBatch(b *testing.B) {
+	const batchSize = 1000
+
+	encryptor := newTestEncryptor(b)
+	payloads := make([][]byte, batchSize)
+	for i := range payloads {
+		payloads[i] = []byte(fmt.Sprintf(`{"id":%d,"timestamp":1700000000000000000}`, i))
+	}
+
+	for _, alg := range []Algorithm{AlgorithmEd25519, AlgorithmECDSAP256} {
+		key, _ := newTestKey(b, encryptor, alg)
+
+		b.Run(string(alg)+"/per-record", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, payload := range payloads {
+					if _, err := encryptor.SignPayload(key, payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "records/s")
+		})
+
+		b.Run(string(alg)+"/per-batch", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				handle, err := encryptor.UnwrapKey(key)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, payload := range payloads {
+					if _, err := handle.Sign(payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+				handle.Destroy()
+			}
+			b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "records/s")
+		})
+	}
+}
+
+func TestUnwrapKeyRejectsSwappedCiphertext(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+
+	first, _ := newTestKey(t, encryptor, AlgorithmEd25519)
+	second, _ := newTestKey(t, encryptor, AlgorithmEd25519)
+	second.ID = 2
+	pkcs8Key, err := encryptor.unwrap(first)
+	if err != nil {
+		t.Fatalf("Failed to unwrap key: %v", err)
+	}
+	if err := encryptor.WrapKey(second, pkcs8Key); err != nil {
+		t.Fatalf("Failed to wrap key: %v", err)
+	}
+
+	first.PrivateKey, second.PrivateKey = second.PrivateKey, first.PrivateKey
+
+	if _, err := encryptor.UnwrapKey(first); err == nil {
+		t.Errorf("Expected unwrap to fail for ciphertext moved from key 2 to key 1")
+	}
+	if _, err := encryptor.UnwrapKey(second); err == nil {
+		t.Errorf("Expected unwrap to fail for ciphertext moved from key 1 to key 2")
+	}
+
+	tampered := *first
+	tampered.PrivateKey = second.PrivateKey
+	tampered.Algorithm = string(AlgorithmECDSAP256)
+	if _, err := encryptor.UnwrapKey(&tampered); err == nil {
+		t.Errorf("Expected unwrap to fail when the algorithm column is changed")
+	}
+}
+
+func TestRewrapLegacyKeyToBoundFormat(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+
+	signer, err := GenerateSigner(AlgorithmEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate signer: %v", err)
+	}
+
+	pkcs8Key, err := MarshalSigner(signer)
+	if err != nil {
+		t.Fatalf("Failed to marshal signer: %v", err)
+	}
+
+	legacy, err := encryptor.Encrypt(pkcs8Key)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	key := &models.SigningKey{
+		ID:         7,
+		PublicKey:  signer.PublicKey(),
+		PrivateKey: legacy,
+		Algorithm:  string(AlgorithmEd25519),
+		KEKVersion: encryptor.ActiveVersion(),
+		WrapFormat: WrapFormatLegacy,
+	}
+
+	if _, err := encryptor.SignPayload(key, []byte("{}")); err != nil {
+		t.Fatalf("Expected legacy key to remain usable: %v", err)
+	}
+
+	changed, err := encryptor.RewrapKey(key)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if !changed || key.WrapFormat != WrapFormatBound {
+		t.Fatalf("Expected key to move to bound format, got changed=%v format=%d", changed, key.WrapFormat)
+	}
+
+	if _, err := encryptor.SignPayload(key, []byte("{}")); err != nil {
+		t.Errorf("Expected rewrapped key to be usable: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmEd25519   Algorithm = "ed25519"
+	AlgorithmECDSAP256 Algorithm = "ecdsa-p256"
+	AlgorithmECDSAP384 Algorithm = "ecdsa-p384"
+	AlgorithmRSAPSS    Algorithm = "rsa-pss"
+)
+
+const rsaKeyBits = 2048
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	alg := Algorithm(strings.ToLower(strings.TrimSpace(s)))
+	switch alg {
+	case AlgorithmEd25519, AlgorithmECDSAP256, AlgorithmECDSAP384, AlgorithmRSAPSS:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm: %q", s)
+	}
+}
+
+// Signer signs payloads with a single private key. PublicKey returns the raw
+// 32-byte key for Ed25519 and the PKIX DER encoding for ECDSA and RSA.
+type Signer interface {
+	Algorithm() Algorithm
+	PublicKey() []byte
+	Sign(payload []byte) ([]byte, error)
+}
+
+func GenerateSigner(alg Algorithm) (Signer, error) {
+	switch alg {
+	case AlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		return NewSigner(key)
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		curve := elliptic.P256()
+		if alg == AlgorithmECDSAP384 {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+		}
+		return NewSigner(key)
+	case AlgorithmRSAPSS:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		return NewSigner(key)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %q", alg)
+	}
+}
+
+func NewSigner(privateKey any) (Signer, error) {
+	switch key := privateKey.(type) {
+	case ed25519.PrivateKey:
+		return &ed25519Signer{key: key}, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return &ecdsaSigner{key: key, alg: AlgorithmECDSAP256, hash: crypto.SHA256}, nil
+		case elliptic.P384():
+			return &ecdsaSigner{key: key, alg: AlgorithmECDSAP384, hash: crypto.SHA384}, nil
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve: %s", key.Curve.Params().Name)
+		}
+	case *rsa.PrivateKey:
+		return &rsaPSSSigner{key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+}
+
+func ParseSigner(pkcs8Key []byte) (Signer, error) {
+	privateKey, err := x509.ParsePKCS8PrivateKey(pkcs8Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return NewSigner(privateKey)
+}
+
+func MarshalSigner(s Signer) ([]byte, error) {
+	var privateKey any
+	switch signer := s.(type) {
+	case *ed25519Signer:
+		privateKey = signer.key
+	case *ecdsaSigner:
+		privateKey = signer.key
+	case *rsaPSSSigner:
+		privateKey = signer.key
+	default:
+		return nil, fmt.Errorf("cannot marshal signer of type %T", s)
+	}
+
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pkcs8Key, nil
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Algorithm() Algorithm { return AlgorithmEd25519 }
+
+func (s *ed25519Signer) PublicKey() []byte {
+	return []byte(s.key.Public().(ed25519.PublicKey))
+}
+
+func (s *ed25519Signer) Sign(payload []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, payload), nil
+}
+
+type ecdsaSigner struct {
+	key  *ecdsa.PrivateKey
+	alg  Algorithm
+	hash crypto.Hash
+}
+
+func (s *ecdsaSigner) Algorithm() Algorithm { return s.alg }
+
+func (s *ecdsaSigner) PublicKey() []byte {
+	der, _ := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	return der
+}
+
+func (s *ecdsaSigner) Sign(payload []byte) ([]byte, error) {
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, digest(s.hash, payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with ECDSA: %w", err)
+	}
+	return signature, nil
+}
+
+type rsaPSSSigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s *rsaPSSSigner) Algorithm() Algorithm { return AlgorithmRSAPSS }
+
+func (s *rsaPSSSigner) PublicKey() []byte {
+	der, _ := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	return der
+}
+
+func (s *rsaPSSSigner) Sign(payload []byte) ([]byte, error) {
+	signature, err := rsa.SignPSS(rand.Reader, s.key, crypto.SHA256, digest(crypto.SHA256, payload),
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with RSA-PSS: %w", err)
+	}
+	return signature, nil
+}
+
+func digest(hash crypto.Hash, payload []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384(payload)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(payload)
+		return sum[:]
+	}
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var allAlgorithms = []Algorithm{
+	AlgorithmEd25519,
+	AlgorithmECDSAP256,
+	AlgorithmECDSAP384,
+	AlgorithmRSAPSS,
+}
+
+func TestSignerMarshalRoundTrip(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			signer, err := GenerateSigner(alg)
+			if err != nil {
+				t.Fatalf("Failed to generate signer: %v", err)
+			}
+
+			pkcs8Key, err := MarshalSigner(signer)
+			if err != nil {
+				t.Fatalf("Failed to marshal signer: %v", err)
+			}
+
+			parsed, err := ParseSigner(pkcs8Key)
+			if err != nil {
+				t.Fatalf("Failed to parse signer: %v", err)
+			}
+
+			if parsed.Algorithm() != alg {
+				t.Errorf("Expected algorithm %s, got %s", alg, parsed.Algorithm())
+			}
+
+			if !bytes.Equal(parsed.PublicKey(), signer.PublicKey()) {
+				t.Errorf("Public key changed after marshal round trip")
+			}
+		})
+	}
+}
+
+func TestSignPayloadAllAlgorithms(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+
+	for _, alg := range allAlgorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			key, _ := newTestKey(t, encryptor, alg)
+
+			signature, err := encryptor.SignPayload(key, []byte(`{"id":1}`))
+			if err != nil {
+				t.Fatalf("Signing failed: %v", err)
+			}
+
+			if len(signature) == 0 {
+				t.Errorf("Expected non-empty signature")
+			}
+		})
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	alg, err := ParseAlgorithm(" ECDSA-P256 ")
+	if err != nil {
+		t.Fatalf("Failed to parse algorithm: %v", err)
+	}
+	if alg != AlgorithmECDSAP256 {
+		t.Errorf("Expected %s, got %s", AlgorithmECDSAP256, alg)
+	}
+
+	if _, err := ParseAlgorithm("dsa"); err == nil {
+		t.Errorf("Expected error for unsupported algorithm")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"id":"a","timestamp":1}`)
+
+	for _, alg := range allAlgorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			signer, err := GenerateSigner(alg)
+			if err != nil {
+				t.Fatalf("Failed to generate signer: %v", err)
+			}
+
+			signature, err := signer.Sign(payload)
+			if err != nil {
+				t.Fatalf("Signing failed: %v", err)
+			}
+
+			if err := Verify(alg, signer.PublicKey(), payload, signature); err != nil {
+				t.Errorf("Expected valid signature, got: %v", err)
+			}
+
+			tampered := append([]byte{}, payload...)
+			tampered[len(tampered)-2] = '2'
+			if err := Verify(alg, signer.PublicKey(), tampered, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature for tampered payload, got: %v", err)
+			}
+
+			other, err := GenerateSigner(alg)
+			if err != nil {
+				t.Fatalf("Failed to generate signer: %v", err)
+			}
+			if err := Verify(alg, other.PublicKey(), payload, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature for wrong key, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Verify checks signature over payload with a public key encoded the way
+// Signer.PublicKey returns it. It returns ErrInvalidSignature when the
+// signature does not match and a different error when the key is unusable.
+func Verify(alg Algorithm, publicKey, payload, signature []byte) error {
+	switch alg {
+	case AlgorithmEd25519:
+		if len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key length: %d", len(publicKey))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(publicKey), payload, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		key, err := parsePublicKey[*ecdsa.PublicKey](publicKey)
+		if err != nil {
+			return err
+		}
+		curve, hash := elliptic.P256(), crypto.SHA256
+		if alg == AlgorithmECDSAP384 {
+			curve, hash = elliptic.P384(), crypto.SHA384
+		}
+		if key.Curve != curve {
+			return fmt.Errorf("public key curve %s does not match algorithm %s", key.Curve.Params().Name, alg)
+		}
+		if !ecdsa.VerifyASN1(key, digest(hash, payload), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmRSAPSS:
+		key, err := parsePublicKey[*rsa.PublicKey](publicKey)
+		if err != nil {
+			return err
+		}
+		err = rsa.VerifyPSS(key, crypto.SHA256, digest(crypto.SHA256, payload), signature,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %q", alg)
+	}
+}
+
+func parsePublicKey[T any](der []byte) (T, error) {
+	var zero T
+
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return zero, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	key, ok := publicKey.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected public key type: %T", publicKey)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,155 @@
+package keyservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+const defaultTimeout = 10 * time.Second
+
+var ErrLeaseConflict = errors.New("key is leased by another holder")
+
+// Client signs through a key service by key ID. It implements
+// crypto.SignerSource, so the worker can use it in place of a local
+// KeyEncryptor.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient(baseURL string, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// NewUnixClient returns a Client for a key service listening on a Unix
+// domain socket, such as cmd/keyagent.
+func NewUnixClient(socketPath string, token string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &Client{
+		baseURL: "http://keyagent",
+		token:   token,
+		http:    &http.Client{Timeout: defaultTimeout, Transport: transport},
+	}
+}
+
+// OpenSigner takes an exclusive lease on the key. The lease is released by
+// Destroy on the returned signer.
+func (c *Client) OpenSigner(ctx context.Context, key *models.SigningKey) (crypto.KeySigner, error) {
+	var lease LeaseResponse
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/keys/%d/lease", key.ID), nil, &lease); err != nil {
+		return nil, err
+	}
+
+	signer := &RemoteSigner{ctx: ctx, client: c, info: lease.KeyInfo, leaseID: lease.LeaseID}
+
+	if key.Algorithm != "" && crypto.Algorithm(key.Algorithm) != lease.Algorithm {
+		signer.Destroy()
+		return nil, fmt.Errorf("key service holds key %d as %s, expected %s", key.ID, lease.Algorithm, key.Algorithm)
+	}
+	if len(key.PublicKey) > 0 && !bytes.Equal(key.PublicKey, lease.PublicKey) {
+		signer.Destroy()
+		return nil, fmt.Errorf("key service public key for key %d does not match the database", key.ID)
+	}
+
+	return signer, nil
+}
+
+// RemoteSigner signs with a key held by the key service. Sign requests are
+// bound to the context the signer was opened with.
+type RemoteSigner struct {
+	ctx     context.Context
+	client  *Client
+	info    KeyInfo
+	leaseID string
+}
+
+func (s *RemoteSigner) Algorithm() crypto.Algorithm {
+	return s.info.Algorithm
+}
+
+func (s *RemoteSigner) PublicKey() []byte {
+	return s.info.PublicKey
+}
+
+func (s *RemoteSigner) Sign(payload []byte) ([]byte, error) {
+	var resp SignResponse
+	path := fmt.Sprintf("/v1/keys/%d/sign", s.info.ID)
+	req := SignRequest{LeaseID: s.leaseID, Payload: payload}
+	if err := s.client.do(s.ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// Destroy releases the lease. The key material never leaves the key service.
+func (s *RemoteSigner) Destroy() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	path := fmt.Sprintf("/v1/keys/%d/lease/%s", s.info.ID, s.leaseID)
+	if err := s.client.do(ctx, http.MethodDelete, path, nil, &struct{}{}); err != nil {
+		log.Printf("Failed to release lease on key %d: %v", s.info.ID, err)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, &reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("key service request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		err := fmt.Errorf("key service %s %s returned %d: %s", method, path, resp.StatusCode, errResp.Error)
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %w", ErrLeaseConflict, err)
+		}
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode key service response: %w", err)
+	}
+
+	return nil
+}
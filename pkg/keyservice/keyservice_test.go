@@ -0,0 +1,148 @@
+package keyservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/arleyar/go-record-signer/pkg/models"
+)
+
+type mapStore map[int]crypto.Signer
+
+func (m mapStore) Signer(ctx context.Context, keyID int) (crypto.Signer, error) {
+	signer, ok := m[keyID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return signer, nil
+}
+
+func newTestServer(t *testing.T, token string) (*httptest.Server, crypto.Signer) {
+	t.Helper()
+
+	signer, err := crypto.GenerateSigner(crypto.AlgorithmECDSAP256)
+	if err != nil {
+		t.Fatalf("Failed to generate signer: %v", err)
+	}
+
+	server := httptest.NewServer(NewServer(mapStore{1: signer}, ServerOptions{Token: token}))
+	t.Cleanup(server.Close)
+
+	return server, signer
+}
+
+func TestRemoteSignerSignsByKeyID(t *testing.T) {
+	server, signer := newTestServer(t, "secret")
+	client := NewClient(server.URL, "secret")
+
+	key := &models.SigningKey{
+		ID:        1,
+		PublicKey: signer.PublicKey(),
+		Algorithm: string(crypto.AlgorithmECDSAP256),
+	}
+
+	remote, err := client.OpenSigner(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Failed to open remote signer: %v", err)
+	}
+	defer remote.Destroy()
+
+	payload := []byte(`{"id":1}`)
+	signature, err := remote.Sign(payload)
+	if err != nil {
+		t.Fatalf("Remote signing failed: %v", err)
+	}
+
+	if err := crypto.Verify(remote.Algorithm(), key.PublicKey, payload, signature); err != nil {
+		t.Errorf("Expected valid signature, got: %v", err)
+	}
+}
+
+func TestRemoteSignerErrors(t *testing.T) {
+	server, signer := newTestServer(t, "secret")
+
+	key := &models.SigningKey{ID: 1, PublicKey: signer.PublicKey()}
+
+	if _, err := NewClient(server.URL, "wrong").OpenSigner(context.Background(), key); err == nil {
+		t.Errorf("Expected error for invalid token")
+	}
+
+	client := NewClient(server.URL, "secret")
+
+	if _, err := client.OpenSigner(context.Background(), &models.SigningKey{ID: 2}); err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+
+	other, err := crypto.GenerateSigner(crypto.AlgorithmECDSAP256)
+	if err != nil {
+		t.Fatalf("Failed to generate signer: %v", err)
+	}
+	if _, err := client.OpenSigner(context.Background(), &models.SigningKey{ID: 1, PublicKey: other.PublicKey()}); err == nil {
+		t.Errorf("Expected error when the public key does not match")
+	}
+}
+
+func TestLeaseIsExclusive(t *testing.T) {
+	server, _ := newTestServer(t, "")
+	client := NewClient(server.URL, "")
+	key := &models.SigningKey{ID: 1}
+
+	first, err := client.OpenSigner(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Failed to open remote signer: %v", err)
+	}
+
+	if _, err := client.OpenSigner(context.Background(), key); !errors.Is(err, ErrLeaseConflict) {
+		t.Fatalf("Expected ErrLeaseConflict for second lease, got: %v", err)
+	}
+
+	first.Destroy()
+
+	if _, err := first.Sign([]byte("{}")); !errors.Is(err, ErrLeaseConflict) {
+		t.Errorf("Expected ErrLeaseConflict when signing after release, got: %v", err)
+	}
+
+	second, err := client.OpenSigner(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected lease to be available after release: %v", err)
+	}
+	second.Destroy()
+}
+
+func TestUnixClient(t *testing.T) {
+	signer, err := crypto.GenerateSigner(crypto.AlgorithmEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate signer: %v", err)
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen on unix socket: %v", err)
+	}
+
+	server := &http.Server{Handler: NewServer(mapStore{1: signer}, ServerOptions{})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	remote, err := NewUnixClient(socketPath, "").OpenSigner(context.Background(), &models.SigningKey{ID: 1})
+	if err != nil {
+		t.Fatalf("Failed to open signer over unix socket: %v", err)
+	}
+	defer remote.Destroy()
+
+	signature, err := remote.Sign([]byte("{}"))
+	if err != nil {
+		t.Fatalf("Signing over unix socket failed: %v", err)
+	}
+
+	if err := crypto.Verify(crypto.AlgorithmEd25519, signer.PublicKey(), []byte("{}"), signature); err != nil {
+		t.Errorf("Expected valid signature, got: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package keyservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arleyar/go-record-signer/pkg/crypto"
+	"github.com/google/uuid"
+)
+
+const DefaultLeaseTTL = 2 * time.Minute
+
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// KeyStore gives the key service access to signing keys by ID. Private key
+// material stays inside the store; the service only ever asks it to sign.
+type KeyStore interface {
+	Signer(ctx context.Context, keyID int) (crypto.Signer, error)
+}
+
+type KeyInfo struct {
+	ID        int              `json:"id"`
+	Algorithm crypto.Algorithm `json:"algorithm"`
+	PublicKey []byte           `json:"public_key"`
+}
+
+type LeaseResponse struct {
+	KeyInfo
+	LeaseID   string    `json:"lease_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SignRequest struct {
+	LeaseID string `json:"lease_id"`
+	Payload []byte `json:"payload"`
+}
+
+type SignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type ServerOptions struct {
+	// Token, when set, must be sent by clients as a bearer token.
+	Token string
+	// LeaseTTL bounds how long a lease survives without a sign request.
+	// Defaults to DefaultLeaseTTL.
+	LeaseTTL time.Duration
+	// Audit, when set, receives one line per lease and sign operation.
+	Audit *log.Logger
+}
+
+type lease struct {
+	id        string
+	expiresAt time.Time
+}
+
+// Server exposes the keys in a KeyStore over HTTP. A key can only be signed
+// with under a lease, and at most one lease per key is live at a time, so
+// the service enforces that no key is used by two workers concurrently.
+type Server struct {
+	store KeyStore
+	opts  ServerOptions
+	mux   *http.ServeMux
+
+	mu     sync.Mutex
+	leases map[int]lease
+}
+
+func NewServer(store KeyStore, opts ServerOptions) *Server {
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = DefaultLeaseTTL
+	}
+
+	s := &Server{
+		store:  store,
+		opts:   opts,
+		mux:    http.NewServeMux(),
+		leases: make(map[int]lease),
+	}
+
+	s.mux.HandleFunc("GET /v1/keys/{id}", s.handleKeyInfo)
+	s.mux.HandleFunc("POST /v1/keys/{id}/lease", s.handleAcquireLease)
+	s.mux.HandleFunc("DELETE /v1/keys/{id}/lease/{lease}", s.handleReleaseLease)
+	s.mux.HandleFunc("POST /v1/keys/{id}/sign", s.handleSign)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			s.audit("denied method=%s path=%s reason=unauthorized", r.Method, r.URL.Path)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleKeyInfo(w http.ResponseWriter, r *http.Request) {
+	keyID, signer, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keyInfo(keyID, signer))
+}
+
+func (s *Server) handleAcquireLease(w http.ResponseWriter, r *http.Request) {
+	keyID, signer, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	current, held := s.leases[keyID]
+	if held && now.Before(current.expiresAt) {
+		s.mu.Unlock()
+		s.audit("lease-denied key=%d holder=%s", keyID, current.id)
+		writeError(w, http.StatusConflict, "key is leased")
+		return
+	}
+
+	granted := lease{id: uuid.New().String(), expiresAt: now.Add(s.opts.LeaseTTL)}
+	s.leases[keyID] = granted
+	s.mu.Unlock()
+
+	if held {
+		s.audit("lease-expired key=%d lease=%s", keyID, current.id)
+	}
+	s.audit("lease key=%d lease=%s", keyID, granted.id)
+
+	writeJSON(w, http.StatusOK, LeaseResponse{
+		KeyInfo:   keyInfo(keyID, signer),
+		LeaseID:   granted.id,
+		ExpiresAt: granted.expiresAt,
+	})
+}
+
+func (s *Server) handleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || keyID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid key id")
+		return
+	}
+
+	leaseID := r.PathValue("lease")
+
+	s.mu.Lock()
+	current, held := s.leases[keyID]
+	if held && current.id == leaseID {
+		delete(s.leases, keyID)
+	}
+	s.mu.Unlock()
+
+	if !held || current.id != leaseID {
+		writeError(w, http.StatusConflict, "lease is not held")
+		return
+	}
+
+	s.audit("release key=%d lease=%s", keyID, leaseID)
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	keyID, signer, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var req SignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid sign request")
+		return
+	}
+
+	if !s.renewLease(keyID, req.LeaseID) {
+		s.audit("sign-denied key=%d lease=%s", keyID, req.LeaseID)
+		writeError(w, http.StatusConflict, "lease is not held")
+		return
+	}
+
+	signature, err := signer.Sign(req.Payload)
+	if err != nil {
+		log.Printf("Failed to sign with key %d: %v", keyID, err)
+		writeError(w, http.StatusInternalServerError, "signing failed")
+		return
+	}
+
+	s.audit("sign key=%d lease=%s payload_sha256=%x", keyID, req.LeaseID, sha256.Sum256(req.Payload))
+	writeJSON(w, http.StatusOK, SignResponse{Signature: signature})
+}
+
+func (s *Server) renewLease(keyID int, leaseID string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, held := s.leases[keyID]
+	if !held || current.id != leaseID || !now.Before(current.expiresAt) {
+		return false
+	}
+
+	current.expiresAt = now.Add(s.opts.LeaseTTL)
+	s.leases[keyID] = current
+	return true
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (int, crypto.Signer, bool) {
+	keyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || keyID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid key id")
+		return 0, nil, false
+	}
+
+	signer, err := s.store.Signer(r.Context(), keyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		writeError(w, http.StatusNotFound, ErrKeyNotFound.Error())
+		return 0, nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load key %d: %v", keyID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load key")
+		return 0, nil, false
+	}
+
+	return keyID, signer, true
+}
+
+func (s *Server) audit(format string, args ...any) {
+	if s.opts.Audit != nil {
+		s.opts.Audit.Printf(format, args...)
+	}
+}
+
+func keyInfo(keyID int, signer crypto.Signer) KeyInfo {
+	return KeyInfo{
+		ID:        keyID,
+		Algorithm: signer.Algorithm(),
+		PublicKey: signer.PublicKey(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,156 @@
+// Package merkle builds RFC 6962 (Certificate Transparency) Merkle trees and
+// inclusion proofs over SHA-256.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const HashSize = sha256.Size
+
+var ErrInvalidProof = errors.New("invalid inclusion proof")
+
+// LeafHash returns SHA-256(0x00 || data).
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// NodeHash returns SHA-256(0x01 || left || right).
+func NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Tree keeps the hashes of every level, from the leaves up to the root, so
+// proofs are read from them instead of being rehashed.
+type Tree struct {
+	levels [][][]byte
+}
+
+// New builds a tree over the given leaf data. Leaves keep the order given.
+//
+// Each level pairs adjacent nodes of the one below and carries an unpaired
+// last node up unchanged, which yields the same tree as RFC 6962's split at
+// the largest power of two smaller than the size.
+func New(data [][]byte) (*Tree, error) {
+	if len(data) == 0 {
+		return nil, errors.New("merkle tree needs at least one leaf")
+	}
+
+	level := make([][]byte, len(data))
+	for i, d := range data {
+		level[i] = LeafHash(d)
+	}
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i+1 < len(level); i += 2 {
+			next = append(next, NodeHash(level[i], level[i+1]))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Tree{levels: levels}, nil
+}
+
+func (t *Tree) Size() int {
+	return len(t.levels[0])
+}
+
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the audit path for the leaf at index, ordered from the leaf
+// up to the root.
+func (t *Tree) Proof(index int) ([][]byte, error) {
+	if index < 0 || index >= t.Size() {
+		return nil, fmt.Errorf("leaf index %d out of range for tree of size %d", index, t.Size())
+	}
+
+	var proof [][]byte
+	for _, level := range t.levels[:len(t.levels)-1] {
+		// A node without a sibling is carried up and adds nothing.
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index >>= 1
+	}
+
+	return proof, nil
+}
+
+// VerifyInclusion checks that leafHash is at index in a tree of size with
+// the given root, following RFC 9162 section 2.1.3.2.
+func VerifyInclusion(index, size int, leafHash []byte, proof [][]byte, root []byte) error {
+	if index < 0 || index >= size {
+		return fmt.Errorf("%w: leaf index %d out of range for tree of size %d", ErrInvalidProof, index, size)
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof is too long", ErrInvalidProof)
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return fmt.Errorf("%w: proof is too short", ErrInvalidProof)
+	}
+
+	if !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// EncodeProof concatenates the proof hashes for storage.
+func EncodeProof(proof [][]byte) []byte {
+	encoded := make([]byte, 0, len(proof)*HashSize)
+	for _, p := range proof {
+		encoded = append(encoded, p...)
+	}
+	return encoded
+}
+
+func DecodeProof(encoded []byte) ([][]byte, error) {
+	if len(encoded)%HashSize != 0 {
+		return nil, fmt.Errorf("%w: length %d is not a multiple of %d", ErrInvalidProof, len(encoded), HashSize)
+	}
+
+	proof := make([][]byte, 0, len(encoded)/HashSize)
+	for i := 0; i < len(encoded); i += HashSize {
+		proof = append(proof, encoded[i:i+HashSize])
+	}
+	return proof, nil
+}
@@ -0,0 +1,105 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRootMatchesRFC6962(t *testing.T) {
+	// Test vectors from the Certificate Transparency reference implementation.
+	leaves := [][]byte{
+		{},
+		{0x00},
+		{0x10},
+		{0x20, 0x21},
+		{0x30, 0x31},
+		{0x40, 0x41, 0x42, 0x43},
+		{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+		{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+	}
+	roots := []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+
+	for n := 1; n <= len(leaves); n++ {
+		tree, err := New(leaves[:n])
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+
+		if got := hex.EncodeToString(tree.Root()); got != roots[n-1] {
+			t.Errorf("Size %d: expected root %s, got %s", n, roots[n-1], got)
+		}
+	}
+}
+
+func TestInclusionProofs(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		data := make([][]byte, size)
+		for i := range data {
+			data[i] = []byte(fmt.Sprintf(`{"id":%d}`, i))
+		}
+
+		tree, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+		root := tree.Root()
+
+		for i := range data {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("Failed to build proof: %v", err)
+			}
+
+			decoded, err := DecodeProof(EncodeProof(proof))
+			if err != nil {
+				t.Fatalf("Failed to decode proof: %v", err)
+			}
+
+			if err := VerifyInclusion(i, size, LeafHash(data[i]), decoded, root); err != nil {
+				t.Errorf("Size %d leaf %d: expected valid proof, got: %v", size, i, err)
+			}
+
+			if err := VerifyInclusion(i, size, LeafHash([]byte("other")), decoded, root); !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("Size %d leaf %d: expected ErrInvalidProof for wrong leaf, got: %v", size, i, err)
+			}
+
+			if size > 1 {
+				if err := VerifyInclusion((i+1)%size, size, LeafHash(data[i]), decoded, root); !errors.Is(err, ErrInvalidProof) {
+					t.Errorf("Size %d leaf %d: expected ErrInvalidProof for wrong index, got: %v", size, i, err)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkTreeWithProofs builds a tree and every inclusion proof, as the
+// worker does for an attested batch, at BATCH_SIZE=10000.
+func BenchmarkTreeWithProofs(b *testing.B) {
+	data := make([][]byte, 10000)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf(`{"id":%d}`, i))
+	}
+
+	for i := 0; i < b.N; i++ {
+		tree, err := New(data)
+		if err != nil {
+			b.Fatalf("Failed to build tree: %v", err)
+		}
+		for j := range data {
+			if _, err := tree.Proof(j); err != nil {
+				b.Fatalf("Failed to build proof: %v", err)
+			}
+		}
+	}
+}
@@ -17,6 +17,10 @@ const (
 	Subject        = "record.batches"
 	MaxAge         = 24 * time.Hour
 	QueueGroupName = "record-signers"
+
+	// DuplicateWindow is how long JetStream remembers message IDs. It
+	// bounds how late a republished outbox message is still deduplicated.
+	DuplicateWindow = 10 * time.Minute
 )
 
 type BatchMessage struct {
@@ -42,18 +46,29 @@ func New(url string) (*NATSClient, error) {
 		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
 	}
 
-	_, err = js.StreamInfo(StreamName)
+	info, err := js.StreamInfo(StreamName)
 	if err != nil {
 		_, err = js.AddStream(&nats.StreamConfig{
-			Name:     StreamName,
-			Subjects: []string{Subject},
-			Storage:  nats.FileStorage,
-			MaxAge:   MaxAge,
+			Name:       StreamName,
+			Subjects:   []string{Subject},
+			Storage:    nats.FileStorage,
+			MaxAge:     MaxAge,
+			Duplicates: DuplicateWindow,
 		})
 		if err != nil {
 			conn.Close()
 			return nil, fmt.Errorf("failed to create stream %s: %w", StreamName, err)
 		}
+	} else if info.Config.Duplicates < DuplicateWindow {
+		// Streams created before the window was set keep the 2 minute
+		// default until it is raised here.
+		config := info.Config
+		config.Duplicates = DuplicateWindow
+		if _, err := js.UpdateStream(&config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to update duplicate window of stream %s: %w", StreamName, err)
+		}
+		log.Printf("Raised duplicate window of stream %s from %v to %v", StreamName, info.Config.Duplicates, DuplicateWindow)
 	}
 
 	return &NATSClient{
@@ -68,26 +83,40 @@ func (c *NATSClient) Close() {
 	}
 }
 
-func (c *NATSClient) PublishBatch(records []*models.Record) error {
+// NewBatchOutboxMessage encodes records claimed as batch batchID as a batch
+// message for the outbox. The batch ID doubles as the message's
+// deduplication ID.
+func NewBatchOutboxMessage(batchID string, records []*models.Record) (*models.OutboxMessage, error) {
 	recordMessages := make([]models.RecordMessage, len(records))
 	for i, record := range records {
 		recordMessages[i] = models.NewRecordMessage(record)
 	}
 
 	msg := BatchMessage{
-		BatchID:   uuid.New().String(),
+		BatchID:   batchID,
 		Records:   recordMessages,
 		CreatedAt: time.Now(),
 	}
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal batch message: %w", err)
+		return nil, fmt.Errorf("failed to marshal batch message: %w", err)
 	}
 
-	_, err = c.js.Publish(Subject, data)
+	return &models.OutboxMessage{
+		MessageID: msg.BatchID,
+		Subject:   Subject,
+		Payload:   data,
+	}, nil
+}
+
+// PublishOutbox publishes an outbox message with its message ID set as
+// Nats-Msg-Id, so JetStream drops a republished copy that arrives within
+// the stream's duplicate window.
+func (c *NATSClient) PublishOutbox(msg *models.OutboxMessage) error {
+	_, err := c.js.Publish(msg.Subject, msg.Payload, nats.MsgId(msg.MessageID))
 	if err != nil {
-		return fmt.Errorf("failed to publish batch message: %w", err)
+		return fmt.Errorf("failed to publish message %s: %w", msg.MessageID, err)
 	}
 
 	return nil
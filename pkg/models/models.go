@@ -11,33 +11,156 @@ const (
 	RecordStatusPending RecordStatus = "PENDING"
 	RecordStatusQueued  RecordStatus = "QUEUED"
 	RecordStatusSigned  RecordStatus = "SIGNED"
+	RecordStatusFailed  RecordStatus = "FAILED"
+)
+
+type KeyState string
+
+const (
+	KeyStateActive  KeyState = "ACTIVE"
+	KeyStateRetired KeyState = "RETIRED"
+	KeyStateRevoked KeyState = "REVOKED"
 )
 
 type Record struct {
-	ID        int             `json:"id,omitempty" gorm:"primaryKey"`
-	Payload   json.RawMessage `json:"payload" gorm:"type:jsonb;not null"`
-	Signature []byte          `json:"signature,omitempty" gorm:"type:bytea"`
-	SignedBy  int             `json:"signed_by,omitempty" gorm:"index"`
-	SignedAt  *time.Time      `json:"signed_at,omitempty"`
-	Status    RecordStatus    `json:"status" gorm:"type:varchar(10);not null;default:'PENDING'"`
+	ID                 int               `json:"id,omitempty" gorm:"primaryKey"`
+	Payload            json.RawMessage   `json:"payload" gorm:"type:jsonb;not null"`
+	SignaturesRequired int               `json:"signatures_required" gorm:"not null;default:1"`
+	Signatures         []RecordSignature `json:"signatures,omitempty" gorm:"foreignKey:RecordID"`
+	SignedAt           *time.Time        `json:"signed_at,omitempty"`
+	Status             RecordStatus      `json:"status" gorm:"type:varchar(10);not null;default:'PENDING'"`
+	QueuedAt           *time.Time        `json:"queued_at,omitempty" gorm:"index"`
+	BatchID            string            `json:"batch_id,omitempty" gorm:"type:varchar(36);not null;default:''"`
+	Attempts           int               `json:"attempts" gorm:"not null;default:0"`
+	LastError          string            `json:"last_error,omitempty" gorm:"type:text;not null;default:''"`
+	Canonicalization   string            `json:"canonicalization" gorm:"type:varchar(10);not null;default:'none'"`
+	AttestationID      *int              `json:"attestation_id,omitempty" gorm:"index"`
+	LeafIndex          *int              `json:"leaf_index,omitempty"`
+	InclusionProof     []byte            `json:"inclusion_proof,omitempty" gorm:"type:bytea"`
+}
+
+// RecordSignature is one key's signature over a record. A record is SIGNED
+// once it has SignaturesRequired of them, each from a different key.
+type RecordSignature struct {
+	ID        int       `json:"id,omitempty" gorm:"primaryKey"`
+	RecordID  int       `json:"record_id" gorm:"not null;uniqueIndex:idx_record_signatures_record_key"`
+	KeyID     int       `json:"key_id" gorm:"not null;uniqueIndex:idx_record_signatures_record_key;index"`
+	Signature []byte    `json:"signature" gorm:"type:bytea;not null"`
+	SignedAt  time.Time `json:"signed_at" gorm:"not null"`
+}
+
+// BatchAttestation is a signature by the batch key over the RFC 6962 Merkle
+// root of the batch's canonical payloads, ordered by record ID.
+type BatchAttestation struct {
+	ID               int       `json:"id,omitempty" gorm:"primaryKey"`
+	BatchID          string    `json:"batch_id" gorm:"type:varchar(36);not null;uniqueIndex"`
+	KeyID            int       `json:"key_id" gorm:"not null;index"`
+	RootHash         []byte    `json:"root_hash" gorm:"type:bytea;not null"`
+	TreeSize         int       `json:"tree_size" gorm:"not null"`
+	Signature        []byte    `json:"signature" gorm:"type:bytea;not null"`
+	Canonicalization string    `json:"canonicalization" gorm:"type:varchar(10);not null;default:'none'"`
+	CreatedAt        time.Time `json:"created_at" gorm:"not null"`
+}
+
+// OutboxMessage is a message written in the same transaction as the state
+// change it announces. The outbox relay publishes it to Subject with
+// MessageID as the JetStream deduplication ID and then sets PublishedAt, or
+// sets FailedAt once it gives up on the message.
+type OutboxMessage struct {
+	ID          int        `json:"id,omitempty" gorm:"primaryKey"`
+	MessageID   string     `json:"message_id" gorm:"type:varchar(64);not null;uniqueIndex"`
+	Subject     string     `json:"subject" gorm:"type:varchar(100);not null"`
+	Payload     []byte     `json:"payload" gorm:"type:bytea;not null"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"not null"`
+	PublishedAt *time.Time `json:"published_at,omitempty" gorm:"index"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	LastError   string     `json:"last_error,omitempty" gorm:"type:text;not null;default:''"`
 }
 
 type RecordMessage struct {
-	ID      int             `json:"id"`
-	Payload json.RawMessage `json:"payload"`
+	ID                 int             `json:"id"`
+	Payload            json.RawMessage `json:"payload"`
+	SignaturesRequired int             `json:"signatures_required,omitempty"`
 }
 
 func NewRecordMessage(r *Record) RecordMessage {
 	return RecordMessage{
-		ID:      r.ID,
-		Payload: r.Payload,
+		ID:                 r.ID,
+		Payload:            r.Payload,
+		SignaturesRequired: r.SignaturesRequired,
 	}
 }
 
 type SigningKey struct {
-	ID         int        `json:"id,omitempty" gorm:"primaryKey"`
-	PublicKey  []byte     `json:"public_key" gorm:"type:bytea;not null"`
-	PrivateKey []byte     `json:"private_key,omitempty" gorm:"type:bytea;not null"`
-	LastUsed   *time.Time `json:"last_used,omitempty"`
-	InUse      bool       `json:"in_use" gorm:"not null;default:false"`
+	ID             int        `json:"id,omitempty" gorm:"primaryKey"`
+	PublicKey      []byte     `json:"public_key" gorm:"type:bytea;not null"`
+	PrivateKey     []byte     `json:"private_key,omitempty" gorm:"type:bytea;not null"`
+	Algorithm      string     `json:"algorithm" gorm:"type:varchar(20);not null;default:'ed25519'"`
+	Label          string     `json:"label,omitempty" gorm:"type:varchar(50);not null;default:'';index"`
+	KEKVersion     int        `json:"kek_version" gorm:"column:kek_version;not null;default:1"`
+	WrapFormat     int        `json:"wrap_format" gorm:"not null;default:1"`
+	LastUsed       *time.Time `json:"last_used,omitempty"`
+	InUse          bool       `json:"in_use" gorm:"not null;default:false"`
+	LeasedBy       string     `json:"leased_by,omitempty" gorm:"type:varchar(100);not null;default:''"`
+	LeasedUntil    *time.Time `json:"leased_until,omitempty"`
+	State          KeyState   `json:"state" gorm:"type:varchar(10);not null;default:'ACTIVE';index"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	NotAfter       *time.Time `json:"not_after,omitempty"`
+	RetiredAt      *time.Time `json:"retired_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"not null;default:now()"`
+	SignatureCount int64      `json:"signature_count" gorm:"not null;default:0"`
+	MaxSignatures  int64      `json:"max_signatures" gorm:"not null;default:0"`
+}
+
+// Usable reports whether the key may sign new records at t. Retired and
+// revoked keys only remain for verification.
+func (k *SigningKey) Usable(t time.Time) bool {
+	if k.State != KeyStateActive || k.QuotaExhausted() {
+		return false
+	}
+	if k.NotBefore != nil && t.Before(*k.NotBefore) {
+		return false
+	}
+	if k.NotAfter != nil && !t.Before(*k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// RemainingSignatures returns how many more signatures the key may produce
+// under MaxSignatures, or -1 if the key has no quota (MaxSignatures is zero).
+func (k *SigningKey) RemainingSignatures() int64 {
+	if k.MaxSignatures <= 0 {
+		return -1
+	}
+	return max(k.MaxSignatures-k.SignatureCount, 0)
+}
+
+func (k *SigningKey) QuotaExhausted() bool {
+	return k.RemainingSignatures() == 0
+}
+
+type KeyEventType string
+
+const (
+	KeyEventCreated   KeyEventType = "CREATED"
+	KeyEventRetired   KeyEventType = "RETIRED"
+	KeyEventRevoked   KeyEventType = "REVOKED"
+	KeyEventValidity  KeyEventType = "VALIDITY"
+	KeyEventQuota     KeyEventType = "QUOTA"
+	KeyEventReclaimed KeyEventType = "RECLAIMED"
+	KeyEventLabel     KeyEventType = "LABEL"
+)
+
+// KeyAuditEvent records a change to the signing key pool: who made it
+// (initdb, rotator, keyadmin) and why.
+type KeyAuditEvent struct {
+	ID        int          `json:"id,omitempty" gorm:"primaryKey"`
+	KeyID     int          `json:"key_id" gorm:"not null;index"`
+	Event     KeyEventType `json:"event" gorm:"type:varchar(20);not null"`
+	Actor     string       `json:"actor" gorm:"type:varchar(50);not null"`
+	Reason    string       `json:"reason" gorm:"type:text;not null;default:''"`
+	CreatedAt time.Time    `json:"created_at" gorm:"not null;index"`
 }